@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+const (
+	ageSuffixEnvVar       = "SYNCRET_AGE_SUFFIX"
+	ageDecryptEnvVar      = "SYNCRET_AGE_DECRYPT"
+	ageIdentityEnvVar     = "SYNCRET_AGE_IDENTITY"
+	ageIdentityFileEnvVar = "SYNCRET_AGE_IDENTITY_FILE"
+)
+
+// instantiates an age decryptor from the environment; identities may come from an identity file, the
+// environment itself, or both
+func newAgeDecryptor(env map[string]string) *ageDecryptor {
+	return &ageDecryptor{
+		identityFile: env[ageIdentityFileEnvVar],
+		identity:     env[ageIdentityEnvVar],
+	}
+}
+
+// decrypts age files in-process; the identities are read once, on first use
+type ageDecryptor struct {
+	identityFile string
+	identity     string
+
+	once       sync.Once
+	identities []age.Identity
+	err        error
+}
+
+func (d *ageDecryptor) Decrypt(path string) ([]byte, error) {
+	if d.once.Do(d.load); d.err != nil {
+		return nil, d.err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var in io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header)) {
+		in = armor.NewReader(in)
+	}
+
+	out, err := age.Decrypt(in, d.identities...)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(out)
+}
+
+// reads the identities
+func (d *ageDecryptor) load() {
+	if d.identityFile == "" && d.identity == "" {
+		d.err = fmt.Errorf("no age identities: set %v or %v", ageIdentityFileEnvVar, ageIdentityEnvVar)
+		return
+	}
+
+	if d.identityFile != "" {
+		data, err := ioutil.ReadFile(d.identityFile)
+		if err != nil {
+			d.err = fmt.Errorf("error reading identities %v: %v", d.identityFile, err)
+			return
+		}
+		if d.err = d.add(string(data)); d.err != nil {
+			return
+		}
+	}
+
+	if d.identity != "" {
+		d.err = d.add(d.identity)
+	}
+}
+
+// parses identities, one per line, and adds them
+func (d *ageDecryptor) add(data string) error {
+	identities, err := age.ParseIdentities(strings.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("error reading age identities: %v", err)
+	}
+
+	d.identities = append(d.identities, identities...)
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// encrypts a message to the given identity, optionally armored
+func testAgeMessage(t *testing.T, to *age.X25519Identity, message string, armored bool) []byte {
+	buf := new(bytes.Buffer)
+	var out io.WriteCloser = nopCloser{buf}
+	if armored {
+		out = armor.NewWriter(buf)
+	}
+
+	plaintext, err := age.Encrypt(out, to.Recipient())
+	if err != nil {
+		t.Fatalf("erred encrypting message: %v", err)
+	}
+	plaintext.Write([]byte(message))
+	plaintext.Close()
+	out.Close()
+	return buf.Bytes()
+}
+
+func Test_ageDecryptor_Decrypt(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("erred generating identity: %v", err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("erred generating identity: %v", err)
+	}
+	identityFile := "# created: today\n" + identity.String() + "\n"
+
+	tests := []struct {
+		name         string
+		env          map[string]string
+		identityFile string
+		data         []byte
+		want         []byte
+		wantErr      bool
+	}{
+		{
+			"identity from env",
+			map[string]string{ageIdentityEnvVar: identity.String()},
+			"",
+			testAgeMessage(t, identity, "thisisjoe", false),
+			[]byte("thisisjoe"),
+			false,
+		},
+		{
+			"armored, identity from file",
+			map[string]string{},
+			identityFile,
+			testAgeMessage(t, identity, "thisisjoe", true),
+			[]byte("thisisjoe"),
+			false,
+		},
+		{
+			"any identity will do",
+			map[string]string{ageIdentityEnvVar: other.String()},
+			identityFile,
+			testAgeMessage(t, identity, "thisisjoe", false),
+			[]byte("thisisjoe"),
+			false,
+		},
+		{
+			"wrong identity",
+			map[string]string{ageIdentityEnvVar: other.String()},
+			"",
+			testAgeMessage(t, identity, "thisisjoe", false),
+			nil,
+			true,
+		},
+		{
+			"bad identity",
+			map[string]string{ageIdentityEnvVar: "AGE-SECRET-KEY-NOPE"},
+			"",
+			testAgeMessage(t, identity, "thisisjoe", false),
+			nil,
+			true,
+		},
+		{
+			"no identities",
+			map[string]string{},
+			"",
+			testAgeMessage(t, identity, "thisisjoe", false),
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpdir := testDir(t)
+			defer os.RemoveAll(tmpdir)
+
+			if tt.identityFile != "" {
+				p := path.Join(tmpdir, "keys.txt")
+				if err := ioutil.WriteFile(p, []byte(tt.identityFile), 0600); err != nil {
+					t.Fatalf("erred writing identities to %v: %v", p, err)
+				}
+				tt.env[ageIdentityFileEnvVar] = p
+			}
+
+			p := path.Join(tmpdir, "secret.age")
+			if err := ioutil.WriteFile(p, tt.data, 0666); err != nil {
+				t.Fatalf("erred writing test data to %v: %v", p, err)
+			}
+
+			got, err := newAgeDecryptor(tt.env).Decrypt(p)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ageDecryptor.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ageDecryptor.Decrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
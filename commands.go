@@ -0,0 +1,240 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+const doc = `Usage of %[1]s COMMAND [flags] [args]:
+
+Synchronizes a directory of encrypted secrets and metadata with AWS's parameter store.
+
+Commands:
+%[2]s
+Run '%[1]s help COMMAND' for more about a command and its flags, which follow it.
+
+For compatibility, '%[1]s [flags] [FILE ...]' without a command plans; or with -commit, applies;
+or with -diff, diffs; taking any of these flags:
+
+`
+
+// the help shared by the commands which sync paths
+const syncDoc = `If files are provided as arguments, they will be used; otherwise, paths will be read from stdin.
+Directories are walked for secret files, and paths dropped, as .syncretignore files (in gitignore syntax) exclude.
+Lines of 'git diff --name-status' output are understood too, so that deleted secret files delete
+their parameters; -git-range runs that diff itself, with any arguments limiting the paths diffed.
+
+Provide -prune with a parameter store path to also delete parameters under that path which no
+longer exist locally; only use it with the full set of secret files for that path, which is why it
+can't be used with a diff.
+`
+
+// flags, by name, shared by several commands
+var (
+	loaderFlags = []string{"prefix", "root", "trim", "kms-key-id", "tag", "parallel"}
+	syncFlags   = join(loaderFlags, []string{"keep-going", "git-range", "prune", "report", "report-file"})
+	ssmFlags    = []string{"retries", "retry-base", "retry-max", "max-rps"}
+)
+
+// a subcommand of syncret
+type command struct {
+	name  string
+	args  string
+	short string   // a line for the list of commands
+	long  string   // more, for the command's own help
+	flags []string // the names of the flags it takes, of those defined for the whole program
+	run   func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	// set here rather than where declared, since help refers back to commands
+	commands = []command{
+		{
+			"plan", "[FILE ...]", "print the metadata of the secrets which would be synced",
+			"Prints the metadata (not the values) of each secret which apply would sync.\n\n" + syncDoc,
+			join(syncFlags, ssmFlags),
+			func(args []string) error { return syncCmd(newPrinter(os.Stdout), args) },
+		},
+		{
+			"apply", "[FILE ...]", "sync secrets to the parameter store, or another target",
+			"Syncs each secret to the parameter store, or wherever -target says.\n\n" + syncDoc,
+			join(syncFlags, ssmFlags, []string{"target", "vault-mount", "kube-namespace", "kube-out", "kube-apply"}),
+			applyCmd,
+		},
+		{
+			"diff", "[FILE ...]", "print how secrets differ from the parameter store",
+			"Prints how each secret differs from the parameter store; values are only shown as hashes.\n\n" + syncDoc,
+			join(syncFlags, ssmFlags),
+			func(args []string) error { return syncCmd(newDiffer(os.Stdout), args) },
+		},
+		{
+			"validate", "[FILE ...]", "check secrets without syncing them",
+			"Checks that every secret decrypts, has a valid name, and would be accepted by the parameter store,\n" +
+				"without syncing it or needing AWS credentials; for a pre-commit hook, say. Files (or directories\n" +
+				"to walk) are read from stdin if not provided as arguments.\n",
+			loaderFlags,
+			validateCmd,
+		},
+		{
+			"pull", "PATH ...", "write the parameters under parameter store paths out as secret files",
+			"Writes each parameter under each parameter store path out as a secret file (encrypted with the\n" +
+				"command SYNCRET_ENCRYPT names, which is passed the value on stdin) and its sidecars, under -root\n" +
+				"and -prefix, so that syncing them straight back changes nothing. Existing files are never\n" +
+				"overwritten.\n",
+			join([]string{"prefix", "root"}, ssmFlags),
+			pullCmd,
+		},
+		{
+			"list", "PATH ...", "list the parameters under parameter store paths",
+			"Lists the name of every parameter under each parameter store path, as -prune would see them.\n",
+			ssmFlags,
+			listCmd,
+		},
+	}
+
+	flag.Usage = func() {
+		var list strings.Builder
+		w := tabwriter.NewWriter(&list, 0, 4, 2, ' ', 0)
+		for _, cmd := range commands {
+			fmt.Fprintf(w, "  %v\t%v\n", cmd.name, cmd.short)
+		}
+		w.Flush()
+
+		fmt.Fprintf(flag.CommandLine.Output(), doc, os.Args[0], list.String())
+		flag.PrintDefaults()
+	}
+}
+
+// lists of flag names as one, without sharing any of them
+func join(lists ...[]string) []string {
+	var joined []string
+	for _, list := range lists {
+		joined = append(joined, list...)
+	}
+	return joined
+}
+
+func lookupCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// the command's own flags; since they share values with the program's flags, parsing either sets the same
+// variables
+func (c command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	for _, name := range c.flags {
+		f := flag.Lookup(name)
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s %s [flags] %s:\n\n%s\n", os.Args[0], c.name, c.args, c.long)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// the command which flags alone ask for, from before there were commands
+func legacyCommand() (command, error) {
+	if *commit && *diffOnly {
+		return command{}, fmt.Errorf("-commit and -diff are mutually exclusive")
+	}
+	if *target != "ssm" && *diffOnly {
+		return command{}, fmt.Errorf("-diff only supports the ssm target")
+	}
+
+	name := "plan"
+	if *commit {
+		name = "apply"
+	} else if *diffOnly {
+		name = "diff"
+	}
+	cmd, _ := lookupCommand(name)
+	return cmd, nil
+}
+
+func applyCmd(args []string) error {
+	if *target != "ssm" && len(prune) > 0 {
+		return fmt.Errorf("-prune only supports the ssm target")
+	}
+
+	handler, err := newTarget(*target)
+	if err != nil {
+		return err
+	}
+	return syncCmd(handler, args)
+}
+
+// sync the secrets at the given paths (or changed in -git-range) with a syncer
+func syncCmd(handler syncer, args []string) error {
+	opts := options{parallel: *parallel, keepGoing: *keepGoing}
+	if *reportFormat != "" {
+		var err error
+		if opts.reporter, err = newReporter(*reportFormat, *reportFile); err != nil {
+			return err
+		}
+	}
+	var lines []string
+	if *gitRange != "" {
+		var err error
+		if lines, err = gitChanges(*rootDir, *gitRange, args); err != nil {
+			return err
+		}
+	} else {
+		lines = getPaths(os.Stdin, args)
+	}
+	if len(prune) > 0 && (*gitRange != "" || isDiff(lines)) {
+		// a diff holds only what changed, so everything else under the roots would look deleted
+		return fmt.Errorf("-prune needs every secret under its paths, not a diff (-git-range or --name-status lines)")
+	}
+	if len(prune) > 0 {
+		opts.pruners = append(opts.pruners, newPruner(prune))
+	}
+
+	loader, err := newLoader()
+	if err != nil {
+		return err
+	}
+
+	paths, deleted := parseChanges(lines)
+	gone, changed, err := loader.Deleted(deleted)
+	if err != nil {
+		return err
+	}
+	paths = append(paths, changed...)
+	if len(gone) > 0 {
+		opts.pruners = append(opts.pruners, deletedPruner(gone))
+	}
+	log.Printf("Found %d paths, %d deleted", len(paths), len(gone))
+
+	return run(loader, handler, paths, opts)
+}
+
+func listCmd(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no paths to list")
+	}
+
+	client := newClient()
+	for _, root := range args {
+		names, err := listNames(client, "/"+strings.Trim(root, "/"))
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	}
+	return nil
+}
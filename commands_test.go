@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_command_flagSet(t *testing.T) {
+	for _, cmd := range commands {
+		t.Run(cmd.name, func(t *testing.T) {
+			// every flag named must exist, or this panics
+			fs := cmd.flagSet()
+			for _, name := range cmd.flags {
+				if fs.Lookup(name) == nil {
+					t.Errorf("command.flagSet() lacks -%v", name)
+				}
+			}
+		})
+	}
+}
+
+func Test_command_flagSet_shared(t *testing.T) {
+	defer func(old string) { *prefix = old }(*prefix)
+
+	cmd, ok := lookupCommand("apply")
+	if !ok {
+		t.Fatal("lookupCommand() found no apply command")
+	}
+	fs := cmd.flagSet()
+	if err := fs.Parse([]string{"-prefix", "secrets/", "a.gpg"}); err != nil {
+		t.Fatalf("flagSet().Parse() error = %v", err)
+	}
+	if *prefix != "secrets/" {
+		t.Errorf("prefix = %v, want secrets/", *prefix)
+	}
+	if args := fs.Args(); len(args) != 1 || args[0] != "a.gpg" {
+		t.Errorf("flagSet().Args() = %v, want [a.gpg]", args)
+	}
+}
+
+func Test_legacyCommand(t *testing.T) {
+	defer func(c, d bool, tgt string) { *commit, *diffOnly, *target = c, d, tgt }(*commit, *diffOnly, *target)
+
+	tests := []struct {
+		name     string
+		commit   bool
+		diffOnly bool
+		target   string
+		want     string
+		wantErr  bool
+	}{
+		{"plans", false, false, "ssm", "plan", false},
+		{"commit applies", true, false, "vault", "apply", false},
+		{"diff diffs", false, true, "ssm", "diff", false},
+		{"not both", true, true, "ssm", "", true},
+		{"diff only ssm", false, true, "vault", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*commit, *diffOnly, *target = tt.commit, tt.diffOnly, tt.target
+			got, err := legacyCommand()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("legacyCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.name != tt.want {
+				t.Errorf("legacyCommand() = %v, want %v", got.name, tt.want)
+			}
+		})
+	}
+}
+
+func Test_syncCmd_pruneDiff(t *testing.T) {
+	defer func(p stringList, r string) { prune, *gitRange = p, r }(prune, *gitRange)
+	prune = stringList{"/prod"}
+
+	*gitRange = "HEAD~1..HEAD"
+	if err := syncCmd(nil, nil); err == nil {
+		t.Errorf("syncCmd() with -prune and -git-range didn't fail")
+	}
+
+	*gitRange = ""
+	if err := syncCmd(nil, []string{"M\tsecrets/prod/KEY.gpg"}); err == nil {
+		t.Errorf("syncCmd() with -prune and name status didn't fail")
+	}
+}
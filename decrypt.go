@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// the prefix of a decryption method selecting an in-process decryptor rather than a command
+const builtinPrefix = "builtin:"
+
+// the in-process decryptors, by name
+var builtins = map[string]func(env map[string]string) decryptor{
+	"openpgp": func(env map[string]string) decryptor {
+		return newOpenPGPDecryptor(env)
+	},
+	"age": func(env map[string]string) decryptor {
+		return newAgeDecryptor(env)
+	},
+}
+
+// instantiates the decryptor for a method: either "builtin:NAME" or a command, with any arguments,
+// to which the path is appended; see splitCommand
+func newDecryptor(method string, env map[string]string) (decryptor, error) {
+	if strings.HasPrefix(method, builtinPrefix) {
+		name := method[len(builtinPrefix):]
+		newBuiltin, ok := builtins[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown builtin decryptor %v", name)
+		}
+		return newBuiltin(env), nil
+	}
+
+	cmd, args := splitCommand(method)
+	if cmd == "" {
+		return nil, fmt.Errorf("empty decryption command")
+	}
+	return execDecryptor{cmd, args}, nil
+}
+
+// a command and its arguments, split on whitespace; unless the whole string is an executable, as it
+// had to be before arguments were allowed, for paths with spaces in them
+func splitCommand(s string) (string, []string) {
+	if strings.ContainsAny(s, " \t") {
+		if _, err := exec.LookPath(s); err == nil {
+			return s, []string{}
+		}
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], fields[1:]
+}
+
+// a decryptor which runs a command, which is passed the path and writes the decrypted value to stdout
+type execDecryptor struct {
+	cmd  string
+	args []string
+}
+
+func (d execDecryptor) Decrypt(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	args := append(append([]string{}, d.args...), path)
+	cmd := exec.Command(d.cmd, args...)
+	cmd.Stderr = os.Stderr
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// for files which aren't encrypted at all; it just reads them
+type plaintextDecryptor struct{}
+
+func (plaintextDecryptor) Decrypt(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func Test_newDecryptor(t *testing.T) {
+	tmpdir := testDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	// an executable with a space in its path, as was always allowed
+	spaced := path.Join(tmpdir, "my decrypt.sh")
+	if err := ioutil.WriteFile(spaced, []byte("#!/bin/sh\ncat \"$1\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		env     map[string]string
+		want    decryptor
+		wantErr bool
+	}{
+		{
+			"command",
+			"cat",
+			nil,
+			execDecryptor{"cat", []string{}},
+			false,
+		},
+		{
+			"command with arguments",
+			"gpg --quiet --decrypt",
+			nil,
+			execDecryptor{"gpg", []string{"--quiet", "--decrypt"}},
+			false,
+		},
+		{
+			"executable with a space",
+			spaced,
+			nil,
+			execDecryptor{spaced, []string{}},
+			false,
+		},
+		{
+			"split unless it's all an executable",
+			spaced + " --quiet",
+			nil,
+			execDecryptor{path.Join(tmpdir, "my"), []string{"decrypt.sh", "--quiet"}},
+			false,
+		},
+		{
+			"builtin",
+			"builtin:openpgp",
+			map[string]string{openpgpKeyEnvVar: "a key"},
+			&openpgpDecryptor{key: "a key"},
+			false,
+		},
+		{
+			"unknown builtin",
+			"builtin:rot13",
+			nil,
+			nil,
+			true,
+		},
+		{
+			"empty",
+			" ",
+			nil,
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newDecryptor(tt.method, tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newDecryptor() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newDecryptor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_execDecryptor_Decrypt(t *testing.T) {
+	type args struct {
+		decryptor execDecryptor
+		path      string
+		data      []byte
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{
+			"simple",
+			args{
+				execDecryptor{"cat", nil},
+				"mypath",
+				[]byte("thisisjoe"),
+			},
+			[]byte("thisisjoe"),
+			false,
+		},
+		{
+			"with arguments",
+			args{
+				execDecryptor{"head", []string{"-c", "4"}},
+				"mypath",
+				[]byte("thisisjoe"),
+			},
+			[]byte("this"),
+			false,
+		},
+		{
+			"missing file",
+			args{
+				execDecryptor{"cat", nil},
+				"",
+				nil,
+			},
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpdir := testDir(t)
+			defer os.RemoveAll(tmpdir)
+
+			p := path.Join(tmpdir, "nonexistent")
+			if tt.args.path != "" {
+				p = path.Join(tmpdir, tt.args.path)
+				if err := ioutil.WriteFile(p, tt.args.data, 0666); err != nil {
+					t.Fatalf("erred writing test data to %v: %v", p, err)
+				}
+			}
+
+			got, err := tt.args.decryptor.Decrypt(p)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("execDecryptor.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("execDecryptor.Decrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
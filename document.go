@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const sopsDecryptEnvVar = "SYNCRET_SOPS_DECRYPT"
+
+// suffixes of documents holding many secrets, each expanded into a secret under the document's path
+var documentSuffixes = []string{".sops.yaml", ".sops.yml", ".sops.json"}
+
+// a single secret within a document; either a bare value or a mapping with its metadata
+type documentEntry struct {
+	Value       string `yaml:"value"`
+	Description string `yaml:"description"`
+	Pattern     string `yaml:"pattern"`
+
+	unknown []string // fields of the mapping which aren't any of the above, likely typos
+}
+
+func (e *documentEntry) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&e.Value); err == nil {
+		return nil
+	}
+
+	// not an error here, since sops' metadata is a mapping too, and is skipped
+	var fields map[string]interface{}
+	if err := unmarshal(&fields); err != nil {
+		return err
+	}
+	for field := range fields {
+		if field != "value" && field != "description" && field != "pattern" {
+			e.unknown = append(e.unknown, field)
+		}
+	}
+	sort.Strings(e.unknown)
+
+	type entry documentEntry // no UnmarshalYAML, so no recursion
+	return unmarshal((*entry)(e))
+}
+
+// parses a decrypted YAML (or JSON) document of secrets, named relative to the given name, in key
+// order; the top-level "sops" key is reserved for sops' own metadata
+func parseDocument(name string, data []byte, trim bool) ([]secret, error) {
+	var entries map[string]*documentEntry // nil for null values
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for key := range entries {
+		if key != "sops" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var secrets []secret
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("empty key")
+		}
+
+		entry := entries[key]
+		if entry == nil {
+			return nil, fmt.Errorf("%v has no value", key)
+		}
+		if len(entry.unknown) > 0 {
+			return nil, fmt.Errorf("%v has unknown fields %v; only value, description and pattern are allowed",
+				key, strings.Join(entry.unknown, ", "))
+		}
+		value := sanitize([]byte(entry.Value), trim)
+		if value == "" {
+			return nil, fmt.Errorf("%v has no value", key)
+		}
+		secrets = append(secrets, secret{
+			Name:        name + "/" + key,
+			Value:       value,
+			Description: sanitize([]byte(entry.Description), trim),
+			Pattern:     sanitize([]byte(entry.Pattern), trim),
+		})
+	}
+	return secrets, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseDocument(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		trim    bool
+		want    []secret
+		wantErr bool
+	}{
+		{
+			"bare values",
+			"B: b\nA: 1234\n",
+			false,
+			[]secret{{Name: "/svc/A", Value: "1234"}, {Name: "/svc/B", Value: "b"}},
+			false,
+		},
+		{
+			"with metadata",
+			"A:\n  value: a\n  description: the a\n  pattern: ^a$\n",
+			false,
+			[]secret{{Name: "/svc/A", Value: "a", Description: "the a", Pattern: "^a$"}},
+			false,
+		},
+		{
+			"json",
+			`{"A": {"value": "a "}, "B": "b"}`,
+			true,
+			[]secret{{Name: "/svc/A", Value: "a"}, {Name: "/svc/B", Value: "b"}},
+			false,
+		},
+		{
+			"skips sops metadata",
+			"A: a\nsops:\n  version: 3.7.3\n",
+			false,
+			[]secret{{Name: "/svc/A", Value: "a"}},
+			false,
+		},
+		{
+			"not a mapping",
+			"- a\n",
+			false,
+			nil,
+			true,
+		},
+		{
+			"null value",
+			"A:\nB: b\n",
+			false,
+			nil,
+			true,
+		},
+		{
+			"empty value",
+			"A: ''\n",
+			false,
+			nil,
+			true,
+		},
+		{
+			"no value in the mapping",
+			"A:\n  description: the a\n",
+			false,
+			nil,
+			true,
+		},
+		{
+			"unknown field",
+			"A:\n  valu: a\n",
+			false,
+			nil,
+			true,
+		},
+		{
+			"nested mapping",
+			"A:\n  nested:\n    value: a\n",
+			false,
+			nil,
+			true,
+		},
+		{
+			"nested too deep",
+			"A:\n  value:\n    nested: a\n",
+			false,
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDocument("/svc", []byte(tt.data), tt.trim)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseDocument() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDocument() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,5 +1,20 @@
 module github.com/energyhub/syncret
 
-go 1.12
+go 1.22.0
 
-require github.com/aws/aws-sdk-go v1.21.8
+require (
+	filippo.io/age v1.2.1
+	github.com/ProtonMail/go-crypto v1.3.0
+	github.com/aws/aws-sdk-go v1.21.8
+	github.com/dlclark/regexp2 v1.11.5
+	github.com/sabhiram/go-gitignore v0.0.0-20210923224102-525f6e181f06
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/cloudflare/circl v1.6.0 // indirect
+	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
+	golang.org/x/crypto v0.33.0 // indirect
+	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+)
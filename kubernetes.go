@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	kubeServerEnvVar = "SYNCRET_KUBE_SERVER"
+	kubeTokenEnvVar  = "SYNCRET_KUBE_TOKEN"
+
+	// where a pod finds its service account's credentials
+	kubeServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	kubeAnnotationPrefix = "syncret/description."
+
+	// the longest name kubernetes allows an object
+	kubeNameMax = 253
+)
+
+var (
+	kubeNamespace = flag.String("kube-namespace", "default", "The namespace of the Secrets -target kubernetes writes")
+	kubeOut       = flag.String("kube-out", "", "A directory for -target kubernetes to write manifests to, rather than stdout")
+	kubeApply     = flag.Bool("kube-apply", false, "Apply -target kubernetes Secrets through the API rather than writing manifests")
+
+	// what kubernetes allows in the keys of a Secret's data
+	kubeKey = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+	// runs of what it doesn't allow in the names of objects
+	kubeNameInvalid = regexp.MustCompile(`[^a-z0-9.-]+`)
+)
+
+// return a new syncer which gathers secrets into kubernetes Secrets, and either writes their manifests or
+// applies them, depending on the flags
+func newKubernetesSyncer() (syncer, error) {
+	s := &kubernetesSyncer{namespace: *kubeNamespace, dir: *kubeOut, out: os.Stdout, groups: make(map[string]*kubeGroup), parents: make(map[string]string)}
+	if *kubeApply {
+		api, err := newKubeClient(envMap(os.Environ()))
+		if err != nil {
+			return nil, err
+		}
+		s.api = api
+	}
+	return s, nil
+}
+
+// the secrets under one parent path, which make up one kubernetes Secret
+type kubeGroup struct {
+	secrets []secret
+	deleted []string
+}
+
+// groups secrets by parent path, e.g. /prod/my-service/DB_URL into the DB_URL key of the prod-my-service
+// Secret; nothing is written until Flush, once the groups are complete
+type kubernetesSyncer struct {
+	namespace string
+	dir       string
+	out       io.Writer
+	api       *kubeClient
+
+	mu      sync.Mutex // guards groups and parents, since secrets may be synced in parallel
+	groups  map[string]*kubeGroup
+	parents map[string]string // by Secret name, since more than one parent path can map to a name
+}
+
+func (s *kubernetesSyncer) Sync(secret secret) (result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, err := s.group(secret.Name)
+	if err != nil {
+		return result{}, err
+	}
+	g.secrets = append(g.secrets, secret)
+	return result{}, nil
+}
+
+func (s *kubernetesSyncer) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, err := s.group(name)
+	if err != nil {
+		return err
+	}
+	g.deleted = append(g.deleted, name)
+	return nil
+}
+
+// the group for a secret name, after checking it can be represented
+func (s *kubernetesSyncer) group(name string) (*kubeGroup, error) {
+	parent, key := path.Split(name)
+	secretName := kubeName(parent)
+	if secretName == "" {
+		return nil, fmt.Errorf("%v has no parent path to name a Secret after", name)
+	}
+	if len(secretName) > kubeNameMax {
+		return nil, fmt.Errorf("%v is too long to name a Secret after (%d characters, at most %d)",
+			parent, len(secretName), kubeNameMax)
+	}
+	if !kubeKey.MatchString(key) {
+		return nil, fmt.Errorf("%v can't be a Secret key", key)
+	}
+	if other, ok := s.parents[secretName]; ok && other != parent {
+		return nil, fmt.Errorf("%v and %v would both be the %v Secret", other, parent, secretName)
+	}
+	s.parents[secretName] = parent
+
+	g, ok := s.groups[parent]
+	if !ok {
+		g = &kubeGroup{}
+		s.groups[parent] = g
+	}
+	return g, nil
+}
+
+// writes or applies every group, in order of parent path
+func (s *kubernetesSyncer) Flush() error {
+	var parents []string
+	for parent := range s.groups {
+		parents = append(parents, parent)
+	}
+	sort.Strings(parents)
+
+	var written int
+	for _, parent := range parents {
+		g := s.groups[parent]
+		manifest := s.manifest(parent, g)
+
+		if s.api != nil {
+			if err := s.api.apply(manifest, g); err != nil {
+				return fmt.Errorf("failed applying %v: %v", manifest.Metadata.Name, err)
+			}
+			continue
+		}
+
+		if len(g.secrets) == 0 {
+			// a manifest without them would delete every other key too
+			log.Printf("Not writing %v, which only has deletions; use -kube-apply to delete keys", manifest.Metadata.Name)
+			continue
+		}
+
+		data, err := yaml.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+
+		if s.dir != "" {
+			fname := filepath.Join(s.dir, manifest.Metadata.Name+".yaml")
+			if err := ioutil.WriteFile(fname, data, 0600); err != nil {
+				return fmt.Errorf("failed writing %v: %v", fname, err)
+			}
+			continue
+		}
+
+		if written > 0 {
+			data = append([]byte("---\n"), data...)
+		}
+		if _, err := s.out.Write(data); err != nil {
+			return err
+		}
+		written++
+	}
+	return nil
+}
+
+// the Secret holding a group's secrets
+func (s *kubernetesSyncer) manifest(parent string, g *kubeGroup) kubeSecret {
+	manifest := kubeSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: kubeMetadata{
+			Name:      kubeName(parent),
+			Namespace: s.namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "syncret"},
+		},
+		Type: "Opaque",
+		Data: make(map[string]string),
+	}
+
+	for _, secret := range g.secrets {
+		key := path.Base(secret.Name)
+		manifest.Data[key] = base64.StdEncoding.EncodeToString([]byte(secret.Value))
+		if secret.Description != "" {
+			if manifest.Metadata.Annotations == nil {
+				manifest.Metadata.Annotations = make(map[string]string)
+			}
+			manifest.Metadata.Annotations[kubeAnnotationPrefix+key] = secret.Description
+		}
+	}
+	return manifest
+}
+
+type kubeSecret struct {
+	APIVersion string            `yaml:"apiVersion" json:"apiVersion"`
+	Kind       string            `yaml:"kind" json:"kind"`
+	Metadata   kubeMetadata      `yaml:"metadata" json:"metadata"`
+	Type       string            `yaml:"type" json:"type"`
+	Data       map[string]string `yaml:"data" json:"data"`
+}
+
+type kubeMetadata struct {
+	Name        string            `yaml:"name" json:"name"`
+	Namespace   string            `yaml:"namespace" json:"namespace"`
+	Labels      map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty" json:"annotations,omitempty"`
+}
+
+// a minimal client of the kubernetes API, authenticating with a bearer token
+type kubeClient struct {
+	client *http.Client
+	server string
+	token  string
+}
+
+// configured from the environment if set, and otherwise the pod's service account
+func newKubeClient(env map[string]string) (*kubeClient, error) {
+	if server := env[kubeServerEnvVar]; server != "" {
+		// e.g. `kubectl proxy`, which handles authentication itself
+		return &kubeClient{http.DefaultClient, strings.TrimRight(server, "/"), env[kubeTokenEnvVar]}, nil
+	}
+
+	host, port := env["KUBERNETES_SERVICE_HOST"], env["KUBERNETES_SERVICE_PORT"]
+	if host == "" {
+		return nil, fmt.Errorf("no kubernetes API: set %v, or run in a pod", kubeServerEnvVar)
+	}
+
+	token, err := ioutil.ReadFile(filepath.Join(kubeServiceAccountDir, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading service account token: %v", err)
+	}
+
+	ca, err := ioutil.ReadFile(filepath.Join(kubeServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading service account CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in service account CA")
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	return &kubeClient{client, "https://" + net.JoinHostPort(host, port), strings.TrimSpace(string(token))}, nil
+}
+
+// merge patches a group's keys into its Secret, removing deleted keys; if there's no such Secret, it's created
+func (c *kubeClient) apply(manifest kubeSecret, g *kubeGroup) error {
+	data := make(map[string]interface{})
+	annotations := make(map[string]interface{})
+	for key, value := range manifest.Data {
+		data[key] = value
+		// a removed description needs removing too
+		annotations[kubeAnnotationPrefix+key] = nil
+	}
+	for key, description := range manifest.Metadata.Annotations {
+		annotations[key] = description
+	}
+	for _, name := range g.deleted {
+		key := path.Base(name)
+		data[key] = nil
+		annotations[kubeAnnotationPrefix+key] = nil
+	}
+
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": manifest.Metadata.Labels, "annotations": annotations},
+		"data":     data,
+	}
+
+	secrets := "/api/v1/namespaces/" + manifest.Metadata.Namespace + "/secrets"
+	status, err := c.do("PATCH", secrets+"/"+manifest.Metadata.Name, "application/merge-patch+json", patch)
+	if status != http.StatusNotFound {
+		return err
+	}
+
+	if len(g.secrets) == 0 {
+		return nil // nothing to delete from
+	}
+	_, err = c.do("POST", secrets, "application/json", manifest)
+	return err
+}
+
+// makes a request to the kubernetes API; errors include the status, if any
+func (c *kubeClient) do(method, path, contentType string, in interface{}) (int, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(method, c.server+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&status)
+		return resp.StatusCode, fmt.Errorf("%v: %v", resp.Status, status.Message)
+	}
+	return resp.StatusCode, nil
+}
+
+// the name of the Secret for a parent path: /prod/my_service/ -> prod-my-service
+func kubeName(parent string) string {
+	name := kubeNameInvalid.ReplaceAllString(strings.ToLower(strings.Trim(parent, "/")), "-")
+	return strings.Trim(name, "-.")
+}
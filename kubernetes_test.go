@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_kubernetesSyncer_Flush(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets []secret
+		deleted []string
+		want    string
+		wantErr bool
+	}{
+		{
+			"groups by parent path",
+			[]secret{
+				{Name: "/prod/my-service/DB_URL", Value: "postgres://db", Description: "The database"},
+				{Name: "/prod/my-service/KEY", Value: "hunter2"},
+				{Name: "/prod/other/KEY", Value: "value"},
+			},
+			nil,
+			`apiVersion: v1
+kind: Secret
+metadata:
+  name: prod-my-service
+  namespace: default
+  labels:
+    app.kubernetes.io/managed-by: syncret
+  annotations:
+    syncret/description.DB_URL: The database
+type: Opaque
+data:
+  DB_URL: cG9zdGdyZXM6Ly9kYg==
+  KEY: aHVudGVyMg==
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: prod-other
+  namespace: default
+  labels:
+    app.kubernetes.io/managed-by: syncret
+type: Opaque
+data:
+  KEY: dmFsdWU=
+`,
+			false,
+		},
+		{
+			"skips groups with only deletions",
+			[]secret{{Name: "/prod/other/KEY", Value: "value"}},
+			[]string{"/prod/my-service/GONE"},
+			`apiVersion: v1
+kind: Secret
+metadata:
+  name: prod-other
+  namespace: default
+  labels:
+    app.kubernetes.io/managed-by: syncret
+type: Opaque
+data:
+  KEY: dmFsdWU=
+`,
+			false,
+		},
+		{
+			"no parent path",
+			[]secret{{Name: "/KEY", Value: "value"}},
+			nil,
+			"",
+			true,
+		},
+		{
+			"parent paths naming the same Secret",
+			[]secret{
+				{Name: "/prod/my-service/KEY", Value: "value"},
+				{Name: "/prod/my_service/KEY", Value: "value"},
+			},
+			nil,
+			"",
+			true,
+		},
+		{
+			"parent path too long",
+			[]secret{{Name: "/" + strings.Repeat("a/", 128) + "KEY", Value: "value"}},
+			nil,
+			"",
+			true,
+		},
+		{
+			"bad key",
+			[]secret{{Name: "/prod/my-service/A KEY", Value: "value"}},
+			nil,
+			"",
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			s := &kubernetesSyncer{namespace: "default", out: out, groups: make(map[string]*kubeGroup), parents: make(map[string]string)}
+
+			var err error
+			for _, secret := range tt.secrets {
+				if _, err = s.Sync(secret); err != nil {
+					break
+				}
+			}
+			for _, name := range tt.deleted {
+				if err == nil {
+					err = s.Delete(name)
+				}
+			}
+			if err == nil {
+				err = s.Flush()
+			}
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("kubernetesSyncer.Flush() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("kubernetesSyncer.Flush() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_kubernetesSyncer_Flush_dir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &kubernetesSyncer{namespace: "default", dir: dir, groups: make(map[string]*kubeGroup), parents: make(map[string]string)}
+	s.Sync(secret{Name: "/prod/my-service/KEY", Value: "value"})
+	if err := s.Flush(); err != nil {
+		t.Fatalf("kubernetesSyncer.Flush() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "prod-my-service.yaml")); err != nil {
+		t.Errorf("kubernetesSyncer.Flush() didn't write manifest: %v", err)
+	}
+}
+
+// a stand-in for the kubernetes API server, recording the requests made of it
+type fakeKubeAPI struct {
+	existing map[string]bool
+	calls    []string
+	bodies   []map[string]interface{}
+}
+
+func (a *fakeKubeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	if r.Method == "PATCH" && !a.existing[r.URL.Path] {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"message": "not found"})
+		return
+	}
+	a.calls = append(a.calls, r.Method+" "+r.URL.Path)
+	a.bodies = append(a.bodies, body)
+}
+
+func Test_kubeClient_apply(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  map[string]bool
+		secrets   []secret
+		deleted   []string
+		wantCalls []string
+		wantData  map[string]interface{}
+		wantErr   bool
+	}{
+		{
+			"patches existing",
+			map[string]bool{"/api/v1/namespaces/default/secrets/prod-my-service": true},
+			[]secret{{Name: "/prod/my-service/KEY", Value: "value"}},
+			[]string{"/prod/my-service/GONE"},
+			[]string{"PATCH /api/v1/namespaces/default/secrets/prod-my-service"},
+			map[string]interface{}{"KEY": "dmFsdWU=", "GONE": nil},
+			false,
+		},
+		{
+			"creates missing",
+			nil,
+			[]secret{{Name: "/prod/my-service/KEY", Value: "value"}},
+			nil,
+			[]string{"POST /api/v1/namespaces/default/secrets"},
+			map[string]interface{}{"KEY": "dmFsdWU="},
+			false,
+		},
+		{
+			"nothing to delete from",
+			nil,
+			nil,
+			[]string{"/prod/my-service/GONE"},
+			nil,
+			nil,
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeKubeAPI{existing: tt.existing}
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			s := &kubernetesSyncer{
+				namespace: "default",
+				api:       &kubeClient{server.Client(), server.URL, "token"},
+				groups:    make(map[string]*kubeGroup),
+				parents:   make(map[string]string),
+			}
+			for _, secret := range tt.secrets {
+				s.Sync(secret)
+			}
+			for _, name := range tt.deleted {
+				s.Delete(name)
+			}
+
+			if err := s.Flush(); (err != nil) != tt.wantErr {
+				t.Errorf("kubeClient.apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(api.calls, tt.wantCalls) {
+				t.Errorf("kubeClient.apply() called %v, want %v", api.calls, tt.wantCalls)
+			}
+			if len(api.bodies) > 0 && !reflect.DeepEqual(api.bodies[0]["data"], tt.wantData) {
+				t.Errorf("kubeClient.apply() data = %v, want %v", api.bodies[0]["data"], tt.wantData)
+			}
+		})
+	}
+}
+
+func Test_kubeName(t *testing.T) {
+	tests := []struct {
+		parent string
+		want   string
+	}{
+		{"/prod/my-service/", "prod-my-service"},
+		{"/Prod/my_service/", "prod-my-service"},
+		{"/", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.parent, func(t *testing.T) {
+			if got := kubeName(tt.parent); got != tt.want {
+				t.Errorf("kubeName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
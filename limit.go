@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+var maxRPS = flag.Float64("max-rps", 0, "The most parameter store calls to make per second (0 for no limit)")
+
+// a token bucket, holding up to burst tokens and refilling at rate per second; waiting takes a token,
+// and when there are none, reserves the next one and sleeps until it's due
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+// a full limiter allowing rate calls per second, in bursts of up to one second's worth
+func newLimiter(rate float64) *limiter {
+	burst := math.Max(1, math.Floor(rate))
+	return &limiter{rate: rate, burst: burst, tokens: burst, last: time.Now(), now: time.Now, sleep: time.Sleep}
+}
+
+func (l *limiter) wait() {
+	l.mu.Lock()
+	now := l.now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		l.sleep(delay)
+	}
+}
+
+// an SSM client which limits the rate of the calls syncret makes
+type limitedClient struct {
+	ssmiface.SSMAPI
+	limit *limiter
+}
+
+func (c limitedClient) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	c.limit.wait()
+	return c.SSMAPI.GetParameter(input)
+}
+
+func (c limitedClient) DescribeParameters(input *ssm.DescribeParametersInput) (*ssm.DescribeParametersOutput, error) {
+	c.limit.wait()
+	return c.SSMAPI.DescribeParameters(input)
+}
+
+func (c limitedClient) GetParametersByPath(input *ssm.GetParametersByPathInput) (*ssm.GetParametersByPathOutput, error) {
+	c.limit.wait()
+	return c.SSMAPI.GetParametersByPath(input)
+}
+
+func (c limitedClient) GetParametersByPathPages(input *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool) error {
+	return pagesByPath(c.GetParametersByPath, input, fn)
+}
+
+func (c limitedClient) PutParameter(input *ssm.PutParameterInput) (*ssm.PutParameterOutput, error) {
+	c.limit.wait()
+	return c.SSMAPI.PutParameter(input)
+}
+
+func (c limitedClient) DeleteParameter(input *ssm.DeleteParameterInput) (*ssm.DeleteParameterOutput, error) {
+	c.limit.wait()
+	return c.SSMAPI.DeleteParameter(input)
+}
+
+func (c limitedClient) ListTagsForResource(input *ssm.ListTagsForResourceInput) (*ssm.ListTagsForResourceOutput, error) {
+	c.limit.wait()
+	return c.SSMAPI.ListTagsForResource(input)
+}
+
+func (c limitedClient) AddTagsToResource(input *ssm.AddTagsToResourceInput) (*ssm.AddTagsToResourceOutput, error) {
+	c.limit.wait()
+	return c.SSMAPI.AddTagsToResource(input)
+}
+
+func (c limitedClient) RemoveTagsFromResource(input *ssm.RemoveTagsFromResourceInput) (*ssm.RemoveTagsFromResourceOutput, error) {
+	c.limit.wait()
+	return c.SSMAPI.RemoveTagsFromResource(input)
+}
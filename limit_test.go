@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_limiter_wait(t *testing.T) {
+	tests := []struct {
+		name       string
+		rate       float64
+		waits      int
+		elapse     time.Duration // between waits
+		wantSleeps []time.Duration
+	}{
+		{"bursts", 3, 3, 0, nil},
+		{"then waits", 2, 4, 0, []time.Duration{500 * time.Millisecond, time.Second}},
+		{"refills", 1, 3, time.Second, nil},
+		{"fractional", 0.5, 2, 0, []time.Duration{2 * time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(0, 0)
+			var sleeps []time.Duration
+
+			l := newLimiter(tt.rate)
+			l.last = now
+			l.now = func() time.Time { return now }
+			l.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+
+			for i := 0; i < tt.waits; i++ {
+				l.wait()
+				now = now.Add(tt.elapse)
+			}
+			if !reflect.DeepEqual(sleeps, tt.wantSleeps) {
+				t.Errorf("limiter.wait() slept %v, want %v", sleeps, tt.wantSleeps)
+			}
+		})
+	}
+}
+
+func Test_limitedClient(t *testing.T) {
+	var waits int
+	l := newLimiter(1)
+	l.sleep = func(time.Duration) { waits++ }
+
+	c := &committer{limitedClient{&MockClient{}, l}}
+	if _, err := c.Sync(secret{Name: "/new"}); err != nil {
+		t.Fatalf("committer.Sync() error = %v", err)
+	}
+	if err := c.Delete("/new"); err != nil {
+		t.Fatalf("committer.Delete() error = %v", err)
+	}
+
+	// the first of the get, put, tag listing, tagging and delete is free
+	if waits != 4 {
+		t.Errorf("committer waited %v times, want %v", waits, 4)
+	}
+}
@@ -1,16 +1,19 @@
 package main
 
 import (
-	"bytes"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 	"unicode"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
 )
 
 const (
@@ -18,70 +21,252 @@ const (
 	secretEnvVar      = "SYNCRET_SUFFIX"
 	descriptionEnvVar = "SYNCRET_DESCRIPTION_SUFFIX"
 	patternEnvVar     = "SYNCRET_PATTERN_SUFFIX"
+	kmsEnvVar         = "SYNCRET_KMS_SUFFIX"
+	typeEnvVar        = "SYNCRET_TYPE_SUFFIX"
+	plaintextEnvVar   = "SYNCRET_PLAINTEXT_SUFFIX"
+	tagsEnvVar        = "SYNCRET_TAGS_SUFFIX"
+	policiesEnvVar    = "SYNCRET_POLICIES_SUFFIX"
 )
 
 var (
+	// the kinds of secret file understood, by the env vars for their suffix and decryption method; plaintext
+	// files have no decryption method
+	formats = []struct {
+		suffixEnvVar, decryptEnvVar string
+	}{
+		{secretEnvVar, decryptEnvVar},
+		{ageSuffixEnvVar, ageDecryptEnvVar},
+		{plaintextEnvVar, ""},
+	}
+
+	// the parameter types a .type file may give, by their lowercase names
+	paramTypes = map[string]string{
+		"string":       ssm.ParameterTypeString,
+		"stringlist":   ssm.ParameterTypeStringList,
+		"securestring": ssm.ParameterTypeSecureString,
+	}
+
 	defaults = map[string]string{
 		decryptEnvVar:     "cat",
 		secretEnvVar:      ".gpg",
+		ageDecryptEnvVar:  "builtin:age",
+		ageSuffixEnvVar:   ".age",
+		sopsDecryptEnvVar: "sops --decrypt",
 		descriptionEnvVar: ".description",
 		patternEnvVar:     ".pattern",
+		kmsEnvVar:         ".kms",
+		typeEnvVar:        ".type",
+		plaintextEnvVar:   ".plain",
+		tagsEnvVar:        ".tags",
+		policiesEnvVar:    ".policies",
 	}
-	prefix  = flag.String("prefix", "", "A prefix present in the FS but not in the parameter store")
-	rootDir = flag.String("root", "", "Directory relative to which paths are interpreted")
-	trim    = flag.Bool("trim", true, "Trim trailing whitespace from input data")
+	prefix   = flag.String("prefix", "", "A prefix present in the FS but not in the parameter store")
+	rootDir  = flag.String("root", "", "Directory relative to which paths are interpreted")
+	trim     = flag.Bool("trim", true, "Trim trailing whitespace from input data")
+	kmsKeyID = flag.String("kms-key-id", "", "The KMS key to encrypt secrets with, unless a .kms file says otherwise")
 )
 
 // instantiates a new loader from CLI flags and the OS environ
-func newLoader() loader {
-	return doNewLoader(envMap(os.Environ()), *prefix, *rootDir, *trim)
+func newLoader() (loader, error) {
+	tags, err := parseTags(tagFlags)
+	if err != nil {
+		return nil, err
+	}
+	return doNewLoader(envMap(os.Environ()), *prefix, *rootDir, *trim, *parallel, *keepGoing, *kmsKeyID, tags)
 }
 
 // the basic implementation of a loader which loads stuff from the FS (the only real impl)
 type fsLoader struct {
-	secretSuffix      string
+	decryptors        map[string]decryptor // by secret suffix
+	documentDecryptor decryptor
 	descriptionSuffix string
 	patternSuffix     string
-	decryptCmd        string
+	kmsSuffix         string // also names the default for a directory, when it's the whole file name
+	typeSuffix        string
+	tagsSuffix        string
+	policiesSuffix    string
+	tags              map[string]string // for every secret, unless its sidecar says otherwise
+	keyID             string            // the default of last resort
 	fsPrefix          string
 	rootDir           string
 	trim              bool
+	parallel          int  // how many secrets to load at once
+	keepGoing         bool // load what's possible, returning the rest as failures
 }
 
 func (l fsLoader) LoadAll(paths []string) ([]secret, error) {
-	var secrets []secret
+	paths, err := l.expand(paths)
+	if err != nil {
+		return nil, err
+	}
 
-	// unique by 'unextended'
+	// when keeping going, errors are collected rather than returned
+	failed := make(failures)
+	fail := func(key string, err error) error {
+		if !l.keepGoing {
+			return err
+		}
+		failed[key] = err
+		return nil
+	}
+
+	// unique by 'unextended'; and since documents hold many, by full path
+	type job struct {
+		s, p string
+		doc  bool
+	}
+	var jobs []job
 	seen := make(map[string]bool)
 	for _, p := range paths {
-		name := unextended(p, l.secretSuffix, l.patternSuffix, l.descriptionSuffix)
-		if name == "" {
-			return nil, fmt.Errorf("unrecognized path: %v", p)
+		if l.isDirectoryDefault(p) || isIgnoreFile(p) {
+			continue
+		}
+
+		if doc := unextended(p, documentSuffixes...); doc != "" {
+			if !seen[p] {
+				seen[p] = true
+				jobs = append(jobs, job{doc, p, true})
+			}
+			continue
 		}
 
+		name := unextended(p, l.suffixes()...)
+		if name == "" {
+			if err := fail(p, fmt.Errorf("unrecognized path: %v", p)); err != nil {
+				return nil, err
+			}
+			continue
+		}
 		if !seen[name] {
 			seen[name] = true
-			secret, err := l.load(name)
-			if err != nil {
-				return nil, err
+			jobs = append(jobs, job{name, p, false})
+		}
+	}
+
+	loaded := make([][]secret, len(jobs))
+	err = forEach(len(jobs), l.parallel, l.keepGoing, func(i int) error {
+		j := jobs[i]
+		if j.doc {
+			secrets, err := l.loadDocument(j.s, j.p)
+			loaded[i] = secrets
+			return err
+		}
+
+		s, err := l.load(j.s)
+		loaded[i] = []secret{s}
+		return err
+	}, func(i int, err error) {
+		if err != nil {
+			failed[jobs[i].p] = err
+			loaded[i] = nil
+		}
+	})
+	if err != nil && !l.keepGoing {
+		return nil, err
+	}
+
+	var secrets []secret
+	names := make(map[string]bool)
+	for i, j := range jobs {
+		for _, secret := range loaded[i] {
+			if names[secret.Name] {
+				err := fmt.Errorf("%v is defined more than once (last in %v)", secret.Name, j.p)
+				if err := fail(secret.Name, err); err != nil {
+					return nil, err
+				}
+				continue
 			}
+			names[secret.Name] = true
 			secrets = append(secrets, secret)
 		}
 	}
 
-	return secrets, nil
+	if len(failed) == 0 {
+		return secrets, nil
+	}
+
+	// which definition of a duplicate is right is anyone's guess, so none are synced
+	var ok []secret
+	for _, secret := range secrets {
+		if failed[secret.Name] == nil {
+			ok = append(ok, secret)
+		}
+	}
+	return ok, failed
+}
+
+func (l fsLoader) Deleted(paths []string) ([]string, []string, error) {
+	var names, reload []string
+
+	// a deleted secret file takes its sidecars with it; a deleted sidecar alone just changes the secret
+	gone := make(map[string]bool)
+	for _, p := range paths {
+		if s := unextended(p, l.secretSuffixes()...); s != "" {
+			gone[s] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		if l.isDirectoryDefault(p) || isIgnoreFile(p) {
+			continue
+		}
+		if ignored, err := l.ignored(p); err != nil {
+			return nil, nil, err
+		} else if ignored {
+			log.Printf("Ignoring deleted %v", p)
+			continue
+		}
+
+		if unextended(p, documentSuffixes...) != "" {
+			// the document is gone, and with it any record of what it held
+			log.Printf("Can't tell which secrets deleted %v held; use -prune to delete them", p)
+			continue
+		}
+
+		s := unextended(p, l.suffixes()...)
+		if s == "" {
+			return nil, nil, fmt.Errorf("unrecognized path: %v", p)
+		}
+
+		if !gone[s] {
+			reload = append(reload, p)
+		} else if !seen[s] {
+			seen[s] = true
+			name, err := l.name(s)
+			if err != nil {
+				return nil, nil, err
+			}
+			names = append(names, name)
+		}
+	}
+
+	return names, reload, nil
+}
+
+// the parameter store name for a given unextended path
+func (l fsLoader) name(s string) (string, error) {
+	if !strings.HasPrefix(s, l.fsPrefix) {
+		return "", fmt.Errorf("path doesn't have expected prefix %v: %v", l.fsPrefix, s)
+	}
+
+	name := s[len(l.fsPrefix):]
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	return name, nil
 }
 
 // loads the secret for a given name, if possible
 func (l fsLoader) load(s string) (secret, error) {
-	if !strings.HasPrefix(s, l.fsPrefix) {
-		return secret{}, fmt.Errorf("path doesn't have expected prefix %v: %v", l.fsPrefix, s)
+	name, err := l.name(s)
+	if err != nil {
+		return secret{}, err
 	}
 
-	secPath := resolve(l.rootDir, s+l.secretSuffix)
-	secVal, err := decrypt(l.decryptCmd, secPath)
+	secVal, d, err := l.decrypt(s)
 	if err != nil {
-		return secret{}, fmt.Errorf("error loading %v: %v", secPath, err)
+		return secret{}, err
 	}
 
 	description, err := readVal(resolve(l.rootDir, s+l.descriptionSuffix))
@@ -94,32 +279,254 @@ func (l fsLoader) load(s string) (secret, error) {
 		return secret{}, err
 	}
 
-	name := s[len(l.fsPrefix):]
-	if !strings.HasPrefix(name, "/") {
-		name = "/" + name
+	// plaintext isn't secret, so unless told otherwise it isn't stored as such
+	paramType, err := l.paramType(s)
+	if err != nil {
+		return secret{}, err
+	}
+	if _, ok := d.(plaintextDecryptor); ok && paramType == "" {
+		paramType = ssm.ParameterTypeString
+	}
+
+	tags, err := l.tagsFor(s)
+	if err != nil {
+		return secret{}, err
+	}
+
+	policyData, err := readVal(resolve(l.rootDir, s+l.policiesSuffix))
+	if err != nil {
+		return secret{}, err
+	}
+	policies, err := parsePolicies(policyData, time.Now())
+	if err != nil {
+		return secret{}, fmt.Errorf("error reading policies for %v: %v", s, err)
 	}
 
-	return secret{
+	sec := secret{
 		Name:        name,
 		Value:       sanitize(secVal, l.trim),
 		Description: sanitize(description, l.trim),
 		Pattern:     sanitize(pattern, l.trim),
-	}, nil
+		Type:        paramType,
+		Tags:        tags,
+		Policies:    policies,
+	}
+
+	if paramType == ssm.ParameterTypeStringList {
+		if err := validateStringList(sec.Value); err != nil {
+			return secret{}, fmt.Errorf("bad value for %v: %v", name, err)
+		}
+	}
+
+	// only SecureStrings are encrypted, so only they have keys
+	if sec.secure() {
+		if sec.KeyID, err = l.keyIDFor(s); err != nil {
+			return secret{}, err
+		}
+	} else if key, err := readVal(resolve(l.rootDir, s+l.kmsSuffix)); err != nil {
+		return secret{}, err
+	} else if len(key) > 0 {
+		return secret{}, fmt.Errorf("%v has a KMS key, but is a %v rather than a SecureString", name, paramType)
+	}
+
+	return sec, nil
+}
+
+// the parameter type given by an unextended path's .type file, if any
+func (l fsLoader) paramType(s string) (string, error) {
+	val, err := readVal(resolve(l.rootDir, s+l.typeSuffix))
+	if err != nil || len(val) == 0 {
+		return "", err
+	}
+
+	name := sanitize(val, true)
+	paramType, ok := paramTypes[strings.ToLower(name)]
+	if !ok {
+		return "", fmt.Errorf("unknown parameter type %v for %v", name, s)
+	}
+	return paramType, nil
+}
+
+// the loader's tags, overridden by those in an unextended path's .tags file; nil if there are none
+func (l fsLoader) tagsFor(s string) (map[string]string, error) {
+	val, err := readVal(resolve(l.rootDir, s+l.tagsSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	own, err := parseTags(strings.Split(string(val), "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading tags for %v: %v", s, err)
+	}
+	if len(own) == 0 && len(l.tags) == 0 {
+		return nil, nil
+	}
+
+	tags := make(map[string]string)
+	for key, value := range l.tags {
+		tags[key] = value
+	}
+	for key, value := range own {
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// StringList values are comma separated, and SSM rejects empty items
+func validateStringList(value string) error {
+	for i, item := range strings.Split(value, ",") {
+		if strings.TrimSpace(item) == "" {
+			return fmt.Errorf("item %d of the StringList is empty", i+1)
+		}
+	}
+	return nil
+}
+
+// the KMS key for an unextended path: from its own sidecar, else its directory's
+func (l fsLoader) keyIDFor(s string) (string, error) {
+	key, err := readVal(resolve(l.rootDir, s+l.kmsSuffix))
+	if err != nil {
+		return "", err
+	}
+	if len(key) > 0 {
+		// whitespace is never part of a key
+		return sanitize(key, true), nil
+	}
+	return l.directoryKeyID(path.Dir(s))
+}
+
+// the KMS key for a directory: the default for the nearest directory with one, else the loader's default
+func (l fsLoader) directoryKeyID(dir string) (string, error) {
+	for ; ; dir = path.Dir(dir) {
+		key, err := readVal(resolve(l.rootDir, path.Join(dir, l.kmsSuffix)))
+		if err != nil {
+			return "", err
+		}
+		if len(key) > 0 {
+			return sanitize(key, true), nil
+		}
+		if dir == "." || dir == "/" {
+			return l.keyID, nil
+		}
+	}
+}
+
+// whether a path is a directory's default rather than a secret's sidecar; it takes effect when the
+// secrets under the directory are synced, not by itself
+func (l fsLoader) isDirectoryDefault(p string) bool {
+	if path.Base(p) != l.kmsSuffix {
+		return false
+	}
+	log.Printf("Skipping %v; sync the secrets under its directory to apply it", p)
+	return true
+}
+
+// loads the secrets in a document, given its unextended path and full path
+func (l fsLoader) loadDocument(s, p string) ([]secret, error) {
+	name, err := l.name(s)
+	if err != nil {
+		return nil, err
+	}
+
+	docPath := resolve(l.rootDir, p)
+	data, err := l.documentDecryptor.Decrypt(docPath)
+	if err != nil {
+		return nil, fmt.Errorf("error loading %v: %v", docPath, err)
+	}
+
+	secrets, err := parseDocument(name, data, l.trim)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %v: %v", docPath, err)
+	}
+
+	// documents have no sidecars, but the secrets in them are as good as in the document's directory
+	key, err := l.directoryKeyID(path.Dir(s))
+	if err != nil {
+		return nil, err
+	}
+	for i := range secrets {
+		secrets[i].KeyID = key
+		if len(l.tags) > 0 {
+			secrets[i].Tags = make(map[string]string)
+			for k, v := range l.tags {
+				secrets[i].Tags[k] = v
+			}
+		}
+	}
+	return secrets, nil
+}
+
+// finds the one secret file for an unextended path, and decrypts it with the decryptor for its suffix
+func (l fsLoader) decrypt(s string) ([]byte, decryptor, error) {
+	var found []string
+	for _, suffix := range l.secretSuffixes() {
+		if _, err := os.Stat(resolve(l.rootDir, s+suffix)); err == nil {
+			found = append(found, suffix)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, nil, fmt.Errorf("no secret file for %v", s)
+	case 1:
+		secPath := resolve(l.rootDir, s+found[0])
+		d := l.decryptors[found[0]]
+		secVal, err := d.Decrypt(secPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error loading %v: %v", secPath, err)
+		}
+		return secVal, d, nil
+	default:
+		return nil, nil, fmt.Errorf("ambiguous secret files for %v: %v", s, strings.Join(found, ", "))
+	}
+}
+
+// the suffixes of secret files, in a stable order
+func (l fsLoader) secretSuffixes() []string {
+	var suffixes []string
+	for suffix := range l.decryptors {
+		suffixes = append(suffixes, suffix)
+	}
+	sort.Strings(suffixes)
+	return suffixes
+}
+
+// every suffix the loader recognizes: secret files followed by their sidecars
+func (l fsLoader) suffixes() []string {
+	return append(l.secretSuffixes(), l.patternSuffix, l.descriptionSuffix, l.kmsSuffix, l.typeSuffix, l.tagsSuffix,
+		l.policiesSuffix)
 }
 
 // responsible for establishing defaults etc.
-func doNewLoader(env map[string]string, prefix, rootDir string, trim bool) loader {
-	envSuffix := func(name string, defaultVal string) string {
-		suffix := strings.TrimLeft(env[name], ".")
-		if suffix == "" {
-			return defaultVal
+func doNewLoader(env map[string]string, prefix, rootDir string, trim bool, parallel int, keepGoing bool,
+	keyID string, tags map[string]string) (loader, error) {
+	envMethod := func(name string) string {
+		if method, ok := env[name]; ok {
+			return method
+		}
+		return defaults[name]
+	}
+
+	decryptors := make(map[string]decryptor)
+	for _, format := range formats {
+		var d decryptor = plaintextDecryptor{}
+		if format.decryptEnvVar != "" {
+			var err error
+			if d, err = newDecryptor(envMethod(format.decryptEnvVar), env); err != nil {
+				return nil, fmt.Errorf("bad %v: %v", format.decryptEnvVar, err)
+			}
 		}
-		return "." + suffix
+
+		suffix := envSuffix(env, format.suffixEnvVar)
+		if _, ok := decryptors[suffix]; ok {
+			return nil, fmt.Errorf("bad %v: %v is already the suffix of another kind of secret file", format.suffixEnvVar, suffix)
+		}
+		decryptors[suffix] = d
 	}
 
-	decryptMethod := defaults[decryptEnvVar]
-	if method, ok := env[decryptEnvVar]; ok {
-		decryptMethod = method
+	documentDecryptor, err := newDecryptor(envMethod(sopsDecryptEnvVar), env)
+	if err != nil {
+		return nil, fmt.Errorf("bad %v: %v", sopsDecryptEnvVar, err)
 	}
 
 	if rootDir != "" {
@@ -132,14 +539,31 @@ func doNewLoader(env map[string]string, prefix, rootDir string, trim bool) loade
 	}
 
 	return fsLoader{
-		secretSuffix:      envSuffix(secretEnvVar, defaults[secretEnvVar]),
-		descriptionSuffix: envSuffix(descriptionEnvVar, defaults[descriptionEnvVar]),
-		patternSuffix:     envSuffix(patternEnvVar, defaults[patternEnvVar]),
-		decryptCmd:        decryptMethod,
+		decryptors:        decryptors,
+		documentDecryptor: documentDecryptor,
+		descriptionSuffix: envSuffix(env, descriptionEnvVar),
+		patternSuffix:     envSuffix(env, patternEnvVar),
+		kmsSuffix:         envSuffix(env, kmsEnvVar),
+		typeSuffix:        envSuffix(env, typeEnvVar),
+		tagsSuffix:        envSuffix(env, tagsEnvVar),
+		policiesSuffix:    envSuffix(env, policiesEnvVar),
+		tags:              tags,
+		keyID:             keyID,
 		fsPrefix:          prefix,
 		rootDir:           rootDir,
 		trim:              trim,
+		parallel:          parallel,
+		keepGoing:         keepGoing,
+	}, nil
+}
+
+// the suffix an env var sets, or else its default
+func envSuffix(env map[string]string, name string) string {
+	suffix := strings.TrimLeft(env[name], ".")
+	if suffix == "" {
+		return defaults[name]
 	}
+	return "." + suffix
 }
 
 // reads a filename, but suppresses os not exist, so nonexistent file is
@@ -152,26 +576,6 @@ func readVal(fname string) ([]byte, error) {
 	return val, nil
 }
 
-func decrypt(decryptCmd, path string) ([]byte, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	cmd := exec.Command(decryptCmd, path)
-	cmd.Stderr = os.Stderr
-
-	var out bytes.Buffer
-	cmd.Stdout = &out
-
-	if err := cmd.Run(); err != nil {
-		return nil, err
-	}
-
-	return out.Bytes(), nil
-}
-
 // convert the os provided env list to a map
 func envMap(environ []string) map[string]string {
 	env := make(map[string]string)
@@ -8,6 +8,13 @@ import (
 	"testing"
 )
 
+// a decryptor which just reads the file
+type fakeDecryptor struct{}
+
+func (fakeDecryptor) Decrypt(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
+
 func setUpFs(rootDir string, vals map[string]string) error {
 	for fname, val := range vals {
 		p := path.Join(rootDir, fname)
@@ -36,8 +43,8 @@ func Test_loader_LoadAll(t *testing.T) {
 		secretSuffix      string
 		descriptionSuffix string
 		patternSuffix     string
-		decryptCmd        string
 		fsPrefix          string
+		parallel          int
 	}
 	type args struct {
 		fnames []string
@@ -56,7 +63,6 @@ func Test_loader_LoadAll(t *testing.T) {
 				secretSuffix:      ".txt",
 				descriptionSuffix: ".description",
 				patternSuffix:     ".pattern",
-				decryptCmd:        "cat",
 			},
 			args{
 				[]string{"test_path.txt"},
@@ -73,7 +79,6 @@ func Test_loader_LoadAll(t *testing.T) {
 				secretSuffix:      ".txt",
 				descriptionSuffix: ".description",
 				patternSuffix:     ".pattern",
-				decryptCmd:        "cat",
 			},
 			args{
 				[]string{"test_path.txt"},
@@ -91,7 +96,6 @@ func Test_loader_LoadAll(t *testing.T) {
 				secretSuffix:      ".txt",
 				descriptionSuffix: ".description",
 				patternSuffix:     ".pattern",
-				decryptCmd:        "cat",
 			},
 			args{
 				[]string{"test_path.txt"},
@@ -109,7 +113,6 @@ func Test_loader_LoadAll(t *testing.T) {
 				secretSuffix:      ".txt",
 				descriptionSuffix: ".description",
 				patternSuffix:     ".pattern",
-				decryptCmd:        "cat",
 			},
 			args{
 				[]string{"test_path.txt"},
@@ -133,7 +136,6 @@ func Test_loader_LoadAll(t *testing.T) {
 				secretSuffix:      ".txt",
 				descriptionSuffix: ".description",
 				patternSuffix:     ".pattern",
-				decryptCmd:        "cat",
 			},
 			args{
 				[]string{"test_path.txt", "test_path2.txt", "test_path2.description"},
@@ -155,7 +157,6 @@ func Test_loader_LoadAll(t *testing.T) {
 				secretSuffix:      ".txt",
 				descriptionSuffix: ".description",
 				patternSuffix:     ".pattern",
-				decryptCmd:        "cat",
 			},
 			args{
 				[]string{"hi/test_path.txt"},
@@ -179,7 +180,6 @@ func Test_loader_LoadAll(t *testing.T) {
 				secretSuffix:      ".txt",
 				descriptionSuffix: ".description",
 				patternSuffix:     ".pattern",
-				decryptCmd:        "cat",
 			},
 			args{
 				[]string{"hi/test_path.txt"},
@@ -197,7 +197,6 @@ func Test_loader_LoadAll(t *testing.T) {
 				secretSuffix:      ".txt",
 				descriptionSuffix: ".description",
 				patternSuffix:     ".pattern",
-				decryptCmd:        "cat",
 			},
 			args{
 				[]string{"hi/test_path.txt.gz"},
@@ -214,7 +213,6 @@ func Test_loader_LoadAll(t *testing.T) {
 				secretSuffix:      ".txt",
 				descriptionSuffix: ".description",
 				patternSuffix:     ".pattern",
-				decryptCmd:        "cat",
 				fsPrefix:          "bar",
 			},
 			args{
@@ -226,6 +224,50 @@ func Test_loader_LoadAll(t *testing.T) {
 			nil,
 			true,
 		},
+		{
+			"loads in parallel, in order",
+			fields{
+				secretSuffix:      ".txt",
+				descriptionSuffix: ".description",
+				patternSuffix:     ".pattern",
+				parallel:          3,
+			},
+			args{
+				[]string{"d.txt", "a.txt", "c.txt", "b.txt", "a.description"},
+				map[string]string{
+					"a.txt":         "a",
+					"a.description": "about a",
+					"b.txt":         "b",
+					"c.txt":         "c",
+					"d.txt":         "d",
+				},
+			},
+			[]secret{
+				{Name: "/d", Value: "d"},
+				{Name: "/a", Value: "a", Description: "about a"},
+				{Name: "/c", Value: "c"},
+				{Name: "/b", Value: "b"},
+			},
+			false,
+		},
+		{
+			"skips ignore files, and what they ignore",
+			fields{
+				secretSuffix:      ".txt",
+				descriptionSuffix: ".description",
+				patternSuffix:     ".pattern",
+			},
+			args{
+				[]string{"a.txt", ".syncretignore", "drafts/b.txt"},
+				map[string]string{
+					"a.txt":          "a",
+					".syncretignore": "drafts/\n",
+					"drafts/b.txt":   "b",
+				},
+			},
+			[]secret{{Name: "/a", Value: "a"}},
+			false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -235,13 +277,17 @@ func Test_loader_LoadAll(t *testing.T) {
 			setUpFs(tmpdir, tt.args.paths)
 
 			l := fsLoader{
-				secretSuffix:      tt.fields.secretSuffix,
+				decryptors:        map[string]decryptor{tt.fields.secretSuffix: fakeDecryptor{}},
 				descriptionSuffix: tt.fields.descriptionSuffix,
 				patternSuffix:     tt.fields.patternSuffix,
-				decryptCmd:        tt.fields.decryptCmd,
+				kmsSuffix:         ".kms",
+				typeSuffix:        ".type",
+				tagsSuffix:        ".tags",
+				policiesSuffix:    ".policies",
 				fsPrefix:          tt.fields.fsPrefix,
 				trim:              false,
 				rootDir:           tmpdir,
+				parallel:          tt.fields.parallel,
 			}
 			got, err := l.LoadAll(tt.args.fnames)
 			if (err != nil) != tt.wantErr {
@@ -255,70 +301,629 @@ func Test_loader_LoadAll(t *testing.T) {
 	}
 }
 
-func Test_readVal(t *testing.T) {
-	type args struct {
-		fname  string
-		data   []byte
-		exists bool
-		mode   os.FileMode
+func Test_loader_LoadAll_keepGoing(t *testing.T) {
+	tmpdir := testDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	setUpFs(tmpdir, map[string]string{
+		"a.txt":          "a",
+		"c.txt":          "c",
+		"x/dupe.txt":     "dupe",
+		"x.sops.yaml":    "dupe: again\n",
+		"other.sops.yml": "{not yaml",
+	})
+
+	l := fsLoader{
+		decryptors:        map[string]decryptor{".txt": fakeDecryptor{}},
+		documentDecryptor: fakeDecryptor{},
+		descriptionSuffix: ".description",
+		patternSuffix:     ".pattern",
+		kmsSuffix:         ".kms",
+		typeSuffix:        ".type",
+		tagsSuffix:        ".tags",
+		policiesSuffix:    ".policies",
+		rootDir:           tmpdir,
+		keepGoing:         true,
+	}
+	got, err := l.LoadAll([]string{"a.txt", "b.txt", "c.txt.gz", "x/dupe.txt", "x.sops.yaml", "other.sops.yml", "c.txt"})
+
+	want := []secret{{Name: "/a", Value: "a"}, {Name: "/c", Value: "c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fsLoader.LoadAll() = %v, want %v", got, want)
 	}
+
+	f, ok := err.(failures)
+	if !ok {
+		t.Fatalf("fsLoader.LoadAll() error = %v, want failures", err)
+	}
+	wantFailed := []string{"/x/dupe", "b.txt", "c.txt.gz", "other.sops.yml"}
+	if !reflect.DeepEqual(f.names(), wantFailed) {
+		t.Errorf("fsLoader.LoadAll() failed = %v, want %v", f.names(), wantFailed)
+	}
+}
+
+func Test_loader_LoadAll_keyID(t *testing.T) {
 	tests := []struct {
 		name    string
-		args    args
-		want    []byte
+		files   map[string]string
+		fnames  []string
+		keyID   string
+		want    []secret
 		wantErr bool
 	}{
 		{
-			"nonexistent",
-			args{
-				"nonexistent_filename",
-				nil,
-				false,
-				0,
-			},
+			"default",
+			map[string]string{"prod/a.txt": "a"},
+			[]string{"prod/a.txt"},
+			"",
+			[]secret{{Name: "/prod/a", Value: "a"}},
+			false,
+		},
+		{
+			"flag",
+			map[string]string{"prod/a.txt": "a"},
+			[]string{"prod/a.txt"},
+			"alias/flag",
+			[]secret{{Name: "/prod/a", Value: "a", KeyID: "alias/flag"}},
+			false,
+		},
+		{
+			"nearest directory over flag",
+			map[string]string{"prod/svc/a.txt": "a", "prod/.kms": "alias/prod\n", ".kms": "alias/top"},
+			[]string{"prod/svc/a.txt"},
+			"alias/flag",
+			[]secret{{Name: "/prod/svc/a", Value: "a", KeyID: "alias/prod"}},
+			false,
+		},
+		{
+			"sidecar over directory",
+			map[string]string{"prod/a.txt": "a", "prod/a.kms": "alias/a", "prod/.kms": "alias/prod"},
+			[]string{"prod/a.txt", "prod/a.kms"},
+			"",
+			[]secret{{Name: "/prod/a", Value: "a", KeyID: "alias/a"}},
+			false,
+		},
+		{
+			"directory defaults aren't secrets",
+			map[string]string{"prod/a.txt": "a", "prod/.kms": "alias/prod"},
+			[]string{"prod/.kms", "prod/a.txt"},
+			"",
+			[]secret{{Name: "/prod/a", Value: "a", KeyID: "alias/prod"}},
+			false,
+		},
+		{
+			"documents use their directory's",
+			map[string]string{"prod/svc.sops.yaml": "a: a", "prod/.kms": "alias/prod"},
+			[]string{"prod/svc.sops.yaml"},
+			"",
+			[]secret{{Name: "/prod/svc/a", Value: "a", KeyID: "alias/prod"}},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpdir := testDir(t)
+			defer os.RemoveAll(tmpdir)
+
+			setUpFs(tmpdir, tt.files)
+
+			l := fsLoader{
+				decryptors:        map[string]decryptor{".txt": fakeDecryptor{}},
+				documentDecryptor: fakeDecryptor{},
+				descriptionSuffix: ".description",
+				patternSuffix:     ".pattern",
+				kmsSuffix:         ".kms",
+				typeSuffix:        ".type",
+				tagsSuffix:        ".tags",
+				policiesSuffix:    ".policies",
+				keyID:             tt.keyID,
+				rootDir:           tmpdir,
+			}
+			got, err := l.LoadAll(tt.fnames)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fsLoader.LoadAll() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fsLoader.LoadAll() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loader_LoadAll_types(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		fname   string
+		want    []secret
+		wantErr bool
+	}{
+		{
+			"secure by default",
+			map[string]string{"a.gpg": "a"},
+			"a.gpg",
+			[]secret{{Name: "/a", Value: "a", KeyID: "alias/prod"}},
+			false,
+		},
+		{
+			"plaintext is a string",
+			map[string]string{"a.txt": "a"},
+			"a.txt",
+			[]secret{{Name: "/a", Value: "a", Type: "String"}},
+			false,
+		},
+		{
+			"type file",
+			map[string]string{"a.gpg": "a,b", "a.type": "stringlist\n"},
+			"a.gpg",
+			[]secret{{Name: "/a", Value: "a,b", Type: "StringList"}},
+			false,
+		},
+		{
+			"plaintext can be secure",
+			map[string]string{"a.txt": "a", "a.type": "SecureString"},
+			"a.txt",
+			[]secret{{Name: "/a", Value: "a", Type: "SecureString", KeyID: "alias/prod"}},
+			false,
+		},
+		{
+			"empty StringList item",
+			map[string]string{"a.txt": "a,,b", "a.type": "StringList"},
+			"a.txt",
+			nil,
+			true,
+		},
+		{
+			"unknown type",
+			map[string]string{"a.txt": "a", "a.type": "Integer"},
+			"a.txt",
+			nil,
+			true,
+		},
+		{
+			"key for a string",
+			map[string]string{"a.txt": "a", "a.kms": "alias/a"},
+			"a.txt",
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpdir := testDir(t)
+			defer os.RemoveAll(tmpdir)
+
+			setUpFs(tmpdir, tt.files)
+
+			l := fsLoader{
+				decryptors:        map[string]decryptor{".gpg": fakeDecryptor{}, ".txt": plaintextDecryptor{}},
+				descriptionSuffix: ".description",
+				patternSuffix:     ".pattern",
+				kmsSuffix:         ".kms",
+				typeSuffix:        ".type",
+				tagsSuffix:        ".tags",
+				policiesSuffix:    ".policies",
+				keyID:             "alias/prod",
+				rootDir:           tmpdir,
+			}
+			got, err := l.LoadAll([]string{tt.fname})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fsLoader.LoadAll() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fsLoader.LoadAll() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loader_LoadAll_tags(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		tags    map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			"none",
+			map[string]string{"a.gpg": "a"},
+			nil,
 			nil,
 			false,
 		},
 		{
-			"present",
-			args{
-				"present_filename",
-				[]byte("1234"),
-				true,
-				0666,
+			"flags",
+			map[string]string{"a.gpg": "a"},
+			map[string]string{"owner": "platform"},
+			map[string]string{"owner": "platform"},
+			false,
+		},
+		{
+			"sidecar over flags",
+			map[string]string{"a.gpg": "a", "a.tags": "owner=payments\nservice=a\n"},
+			map[string]string{"owner": "platform", "env": "prod"},
+			map[string]string{"owner": "payments", "service": "a", "env": "prod"},
+			false,
+		},
+		{
+			"bad sidecar",
+			map[string]string{"a.gpg": "a", "a.tags": "owner\n"},
+			nil,
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpdir := testDir(t)
+			defer os.RemoveAll(tmpdir)
+
+			setUpFs(tmpdir, tt.files)
+
+			l := fsLoader{
+				decryptors:        map[string]decryptor{".gpg": fakeDecryptor{}},
+				descriptionSuffix: ".description",
+				patternSuffix:     ".pattern",
+				kmsSuffix:         ".kms",
+				typeSuffix:        ".type",
+				tagsSuffix:        ".tags",
+				policiesSuffix:    ".policies",
+				tags:              tt.tags,
+				rootDir:           tmpdir,
+			}
+			got, err := l.LoadAll([]string{"a.gpg"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fsLoader.LoadAll() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && !reflect.DeepEqual(got[0].Tags, tt.want) {
+				t.Errorf("fsLoader.LoadAll() tags = %v, want %v", got[0].Tags, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loader_LoadAll_policies(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    []string
+		wantErr bool
+	}{
+		{"none", map[string]string{"a.gpg": "a"}, nil, false},
+		{
+			"sidecar",
+			map[string]string{"a.gpg": "a", "a.policies": "expire-after: 90d\nnotify-unchanged-after: 30d\n"},
+			[]string{"Expiration", "NoChangeNotification"},
+			false,
+		},
+		{"bad sidecar", map[string]string{"a.gpg": "a", "a.policies": "expire-after: soon\n"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpdir := testDir(t)
+			defer os.RemoveAll(tmpdir)
+
+			setUpFs(tmpdir, tt.files)
+
+			l := fsLoader{
+				decryptors:        map[string]decryptor{".gpg": fakeDecryptor{}},
+				descriptionSuffix: ".description",
+				patternSuffix:     ".pattern",
+				kmsSuffix:         ".kms",
+				typeSuffix:        ".type",
+				tagsSuffix:        ".tags",
+				policiesSuffix:    ".policies",
+				rootDir:           tmpdir,
+			}
+			got, err := l.LoadAll([]string{"a.gpg"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fsLoader.LoadAll() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			var types []string
+			for _, p := range got[0].Policies {
+				types = append(types, p.Type)
+			}
+			if !reflect.DeepEqual(types, tt.want) {
+				t.Errorf("fsLoader.LoadAll() policies = %v, want %v", types, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loader_LoadAll_documentTags(t *testing.T) {
+	tmpdir := testDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	setUpFs(tmpdir, map[string]string{"prod.sops.yaml": "A: a\nB: b\n"})
+
+	l := fsLoader{
+		decryptors:        map[string]decryptor{".gpg": fakeDecryptor{}},
+		documentDecryptor: fakeDecryptor{},
+		descriptionSuffix: ".description",
+		patternSuffix:     ".pattern",
+		kmsSuffix:         ".kms",
+		typeSuffix:        ".type",
+		tagsSuffix:        ".tags",
+		policiesSuffix:    ".policies",
+		tags:              map[string]string{"owner": "platform"},
+		rootDir:           tmpdir,
+	}
+	got, err := l.LoadAll([]string{"prod.sops.yaml"})
+	if err != nil {
+		t.Fatalf("fsLoader.LoadAll() error = %v", err)
+	}
+	for _, s := range got {
+		if !reflect.DeepEqual(s.Tags, l.tags) {
+			t.Errorf("fsLoader.LoadAll() tags of %v = %v, want %v", s.Name, s.Tags, l.tags)
+		}
+	}
+	got[0].Tags["owner"] = "changed"
+	if got[1].Tags["owner"] != "platform" {
+		t.Errorf("fsLoader.LoadAll() shares tags between secrets")
+	}
+}
+
+// a decryptor which tags what it reads, to tell decryptors apart
+type taggedDecryptor string
+
+func (d taggedDecryptor) Decrypt(path string) ([]byte, error) {
+	val, err := ioutil.ReadFile(path)
+	return append([]byte(d), val...), err
+}
+
+func Test_loader_LoadAll_picksDecryptor(t *testing.T) {
+	tests := []struct {
+		name    string
+		fnames  []string
+		paths   map[string]string
+		want    []secret
+		wantErr bool
+	}{
+		{
+			"by suffix",
+			[]string{"a.gpg", "b.age"},
+			map[string]string{
+				"a.gpg": "a",
+				"b.age": "b",
 			},
-			[]byte("1234"),
+			[]secret{{Name: "/a", Value: "gpg:a"}, {Name: "/b", Value: "age:b"}},
+			false,
+		},
+		{
+			"from a sidecar",
+			[]string{"b.description"},
+			map[string]string{
+				"b.age":         "b",
+				"b.description": "desc",
+			},
+			[]secret{{Name: "/b", Value: "age:b", Description: "desc"}},
 			false,
 		},
+		{
+			"ambiguous is an error",
+			[]string{"a.gpg"},
+			map[string]string{
+				"a.gpg": "a",
+				"a.age": "a",
+			},
+			nil,
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			tmpdir := testDir(t)
 			defer os.RemoveAll(tmpdir)
 
-			p := path.Join(tmpdir, tt.args.fname)
-			if tt.args.exists {
-				if err := ioutil.WriteFile(p, tt.args.data, tt.args.mode); err != nil {
-					t.Fatalf("Failed writing test dtata to %v: %v", p, err)
-				}
+			setUpFs(tmpdir, tt.paths)
+
+			l := fsLoader{
+				decryptors: map[string]decryptor{
+					".gpg": taggedDecryptor("gpg:"),
+					".age": taggedDecryptor("age:"),
+				},
+				descriptionSuffix: ".description",
+				patternSuffix:     ".pattern",
+				kmsSuffix:         ".kms",
+				typeSuffix:        ".type",
+				tagsSuffix:        ".tags",
+				policiesSuffix:    ".policies",
+				rootDir:           tmpdir,
+			}
+			got, err := l.LoadAll(tt.fnames)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fsLoader.LoadAll() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fsLoader.LoadAll() = %v, want %v", got, tt.want)
 			}
+		})
+	}
+}
 
-			got, err := readVal(p)
+func Test_loader_LoadAll_documents(t *testing.T) {
+	tests := []struct {
+		name    string
+		fnames  []string
+		paths   map[string]string
+		want    []secret
+		wantErr bool
+	}{
+		{
+			"expands document",
+			[]string{"prod/svc.sops.yaml"},
+			map[string]string{
+				"prod/svc.sops.yaml": "B: b\nA:\n  value: a\n  description: the a\n",
+			},
+			[]secret{
+				{Name: "/prod/svc/A", Value: "a", Description: "the a"},
+				{Name: "/prod/svc/B", Value: "b"},
+			},
+			false,
+		},
+		{
+			"alongside files",
+			[]string{"prod/svc/C.gpg", "prod/svc.sops.json", "prod/svc.sops.json"},
+			map[string]string{
+				"prod/svc/C.gpg":     "c",
+				"prod/svc.sops.json": `{"A": "a"}`,
+			},
+			[]secret{
+				{Name: "/prod/svc/C", Value: "c"},
+				{Name: "/prod/svc/A", Value: "a"},
+			},
+			false,
+		},
+		{
+			"defined twice is an error",
+			[]string{"prod/svc/A.gpg", "prod/svc.sops.yml"},
+			map[string]string{
+				"prod/svc/A.gpg":    "a",
+				"prod/svc.sops.yml": "A: a",
+			},
+			nil,
+			true,
+		},
+		{
+			"unparseable is an error",
+			[]string{"prod/svc.sops.yaml"},
+			map[string]string{
+				"prod/svc.sops.yaml": "- a\n- b\n",
+			},
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpdir := testDir(t)
+			defer os.RemoveAll(tmpdir)
+
+			setUpFs(tmpdir, tt.paths)
+
+			l := fsLoader{
+				decryptors:        map[string]decryptor{".gpg": fakeDecryptor{}},
+				documentDecryptor: fakeDecryptor{},
+				descriptionSuffix: ".description",
+				patternSuffix:     ".pattern",
+				kmsSuffix:         ".kms",
+				typeSuffix:        ".type",
+				tagsSuffix:        ".tags",
+				policiesSuffix:    ".policies",
+				rootDir:           tmpdir,
+			}
+			got, err := l.LoadAll(tt.fnames)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("readVal() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("fsLoader.LoadAll() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("readVal() = %v, want %v", got, tt.want)
+				t.Errorf("fsLoader.LoadAll() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loader_Deleted(t *testing.T) {
+	tests := []struct {
+		name       string
+		fsPrefix   string
+		paths      []string
+		wantNames  []string
+		wantReload []string
+		wantErr    bool
+	}{
+		{
+			"secret with sidecars",
+			"secrets/",
+			[]string{"secrets/a/KEY.gpg", "secrets/a/KEY.description", "secrets/a/KEY.pattern"},
+			[]string{"/a/KEY"},
+			nil,
+			false,
+		},
+		{
+			"sidecar alone reloads",
+			"secrets/",
+			[]string{"secrets/a/KEY.description"},
+			nil,
+			[]string{"secrets/a/KEY.description"},
+			false,
+		},
+		{
+			"documents are skipped",
+			"secrets/",
+			[]string{"secrets/a.sops.yaml"},
+			nil,
+			nil,
+			false,
+		},
+		{
+			"ignore files are skipped",
+			"secrets/",
+			[]string{"secrets/.syncretignore", "secrets/a/.syncretignore"},
+			nil,
+			nil,
+			false,
+		},
+		{
+			"unknown extension is an error",
+			"secrets/",
+			[]string{"secrets/a/KEY.txt"},
+			nil,
+			nil,
+			true,
+		},
+		{
+			"missing prefix is an error",
+			"secrets/",
+			[]string{"other/a/KEY.gpg"},
+			nil,
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := fsLoader{
+				decryptors:        map[string]decryptor{".gpg": fakeDecryptor{}},
+				descriptionSuffix: ".description",
+				patternSuffix:     ".pattern",
+				kmsSuffix:         ".kms",
+				typeSuffix:        ".type",
+				tagsSuffix:        ".tags",
+				policiesSuffix:    ".policies",
+				fsPrefix:          tt.fsPrefix,
+			}
+			names, reload, err := l.Deleted(tt.paths)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fsLoader.Deleted() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("fsLoader.Deleted() names = %v, want %v", names, tt.wantNames)
+			}
+			if !reflect.DeepEqual(reload, tt.wantReload) {
+				t.Errorf("fsLoader.Deleted() reload = %v, want %v", reload, tt.wantReload)
 			}
 		})
 	}
 }
 
-func Test_decrypt(t *testing.T) {
+func Test_readVal(t *testing.T) {
 	type args struct {
-		decryptCmd string
-		path       string
+		fname  string
+		data   []byte
+		exists bool
+		mode   os.FileMode
 	}
 	tests := []struct {
 		name    string
@@ -327,12 +932,25 @@ func Test_decrypt(t *testing.T) {
 		wantErr bool
 	}{
 		{
-			"simple",
+			"nonexistent",
+			args{
+				"nonexistent_filename",
+				nil,
+				false,
+				0,
+			},
+			nil,
+			false,
+		},
+		{
+			"present",
 			args{
-				"cat",
-				"mypath",
+				"present_filename",
+				[]byte("1234"),
+				true,
+				0666,
 			},
-			[]byte("thisisjoe"),
+			[]byte("1234"),
 			false,
 		},
 	}
@@ -341,18 +959,20 @@ func Test_decrypt(t *testing.T) {
 			tmpdir := testDir(t)
 			defer os.RemoveAll(tmpdir)
 
-			p := path.Join(tmpdir, tt.args.path)
-			if err := ioutil.WriteFile(p, tt.want, 0666); err != nil {
-				t.Fatalf("erred writing test data to %v: %v", p, err)
+			p := path.Join(tmpdir, tt.args.fname)
+			if tt.args.exists {
+				if err := ioutil.WriteFile(p, tt.args.data, tt.args.mode); err != nil {
+					t.Fatalf("Failed writing test dtata to %v: %v", p, err)
+				}
 			}
 
-			got, err := decrypt(tt.args.decryptCmd, p)
+			got, err := readVal(p)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("readVal() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("decrypt() = %v, want %v", got, tt.want)
+				t.Errorf("readVal() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -452,13 +1072,20 @@ func Test_newOptions(t *testing.T) {
 				true,
 			},
 			fsLoader{
-				".gpg",
-				".description",
-				".pattern",
-				"cat",
-				"",
-				"",
-				true,
+				decryptors: map[string]decryptor{
+					".gpg":   execDecryptor{"cat", []string{}},
+					".age":   &ageDecryptor{},
+					".plain": plaintextDecryptor{},
+				},
+				documentDecryptor: execDecryptor{"sops", []string{"--decrypt"}},
+				descriptionSuffix: ".description",
+				patternSuffix:     ".pattern",
+				kmsSuffix:         ".kms",
+				typeSuffix:        ".type",
+				tagsSuffix:        ".tags",
+				policiesSuffix:    ".policies",
+				trim:              true,
+				parallel:          1,
 			},
 			false,
 		},
@@ -466,8 +1093,11 @@ func Test_newOptions(t *testing.T) {
 			"overrides",
 			args{
 				map[string]string{
-					decryptEnvVar:     "gpg",
-					secretEnvVar:      ".txt",
+					decryptEnvVar:     "gpg --decrypt",
+					secretEnvVar:      ".asc",
+					plaintextEnvVar:   "text",
+					ageDecryptEnvVar:  "rage -d",
+					ageSuffixEnvVar:   "rage",
 					descriptionEnvVar: ".desc",
 					patternEnvVar:     ".patt",
 				},
@@ -476,20 +1106,89 @@ func Test_newOptions(t *testing.T) {
 				false,
 			},
 			fsLoader{
-				".txt",
-				".desc",
-				".patt",
-				"gpg",
-				"blah/",
-				"/tmp",
-				false,
+				decryptors: map[string]decryptor{
+					".asc":  execDecryptor{"gpg", []string{"--decrypt"}},
+					".rage": execDecryptor{"rage", []string{"-d"}},
+					".text": plaintextDecryptor{},
+				},
+				documentDecryptor: execDecryptor{"sops", []string{"--decrypt"}},
+				descriptionSuffix: ".desc",
+				patternSuffix:     ".patt",
+				kmsSuffix:         ".kms",
+				typeSuffix:        ".type",
+				tagsSuffix:        ".tags",
+				policiesSuffix:    ".policies",
+				fsPrefix:          "blah/",
+				rootDir:           "/tmp",
+				trim:              false,
+				parallel:          1,
 			},
 			false,
 		},
+		{
+			"builtin openpgp",
+			args{
+				map[string]string{
+					decryptEnvVar:           "builtin:openpgp",
+					openpgpKeyringEnvVar:    "/keys.gpg",
+					openpgpPassphraseEnvVar: "hunter2",
+				},
+				"",
+				"",
+				true,
+			},
+			fsLoader{
+				decryptors: map[string]decryptor{
+					".gpg":   &openpgpDecryptor{keyringFile: "/keys.gpg", passphrase: "hunter2"},
+					".age":   &ageDecryptor{},
+					".plain": plaintextDecryptor{},
+				},
+				documentDecryptor: execDecryptor{"sops", []string{"--decrypt"}},
+				descriptionSuffix: ".description",
+				patternSuffix:     ".pattern",
+				kmsSuffix:         ".kms",
+				typeSuffix:        ".type",
+				tagsSuffix:        ".tags",
+				policiesSuffix:    ".policies",
+				trim:              true,
+				parallel:          1,
+			},
+			false,
+		},
+		{
+			"clashing suffixes",
+			args{
+				map[string]string{
+					plaintextEnvVar: ".gpg",
+				},
+				"",
+				"",
+				true,
+			},
+			nil,
+			true,
+		},
+		{
+			"unknown builtin",
+			args{
+				map[string]string{
+					decryptEnvVar: "builtin:rot13",
+				},
+				"",
+				"",
+				true,
+			},
+			nil,
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := doNewLoader(tt.args.env, tt.args.prefix, tt.args.rootDir, tt.args.trim)
+			got, err := doNewLoader(tt.args.env, tt.args.prefix, tt.args.rootDir, tt.args.trim, 1, false, "", nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("doNewLoader() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("doNewLoader() = %v, want %v", got, tt.want)
 			}
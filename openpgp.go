@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+const (
+	openpgpKeyringEnvVar    = "SYNCRET_OPENPGP_KEYRING"
+	openpgpKeyEnvVar        = "SYNCRET_OPENPGP_KEY"
+	openpgpPassphraseEnvVar = "SYNCRET_OPENPGP_PASSPHRASE"
+
+	armorPrefix = "-----BEGIN "
+)
+
+// instantiates an OpenPGP decryptor from the environment; keys may come from a keyring file, the
+// environment itself, or both, armored or not
+func newOpenPGPDecryptor(env map[string]string) *openpgpDecryptor {
+	return &openpgpDecryptor{
+		keyringFile: env[openpgpKeyringEnvVar],
+		key:         env[openpgpKeyEnvVar],
+		passphrase:  env[openpgpPassphraseEnvVar],
+	}
+}
+
+// decrypts OpenPGP messages in-process, rather than spawning a process per secret; the private
+// keys are read and unlocked once, on first use
+type openpgpDecryptor struct {
+	keyringFile string
+	key         string
+	passphrase  string
+
+	once sync.Once
+	keys openpgp.EntityList
+	err  error
+}
+
+func (d *openpgpDecryptor) Decrypt(path string) ([]byte, error) {
+	if d.once.Do(d.load); d.err != nil {
+		return nil, d.err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var in io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armorPrefix)) {
+		block, err := armor.Decode(in)
+		if err != nil {
+			return nil, err
+		}
+		in = block.Body
+	}
+
+	md, err := openpgp.ReadMessage(in, d.keys, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// only available once the body is read; signatures by unknown keys can't be checked, like gpg
+	if md.IsSigned && md.SignedBy != nil && md.SignatureError != nil {
+		return nil, fmt.Errorf("bad signature: %v", md.SignatureError)
+	}
+	return out, nil
+}
+
+// reads and unlocks the private keys
+func (d *openpgpDecryptor) load() {
+	if d.keyringFile == "" && d.key == "" {
+		d.err = fmt.Errorf("no OpenPGP keys: set %v or %v", openpgpKeyringEnvVar, openpgpKeyEnvVar)
+		return
+	}
+
+	if d.keyringFile != "" {
+		data, err := ioutil.ReadFile(d.keyringFile)
+		if err != nil {
+			d.err = fmt.Errorf("error reading keyring %v: %v", d.keyringFile, err)
+			return
+		}
+		if d.err = d.add(data); d.err != nil {
+			return
+		}
+	}
+
+	if d.key != "" {
+		if d.err = d.add([]byte(d.key)); d.err != nil {
+			return
+		}
+	}
+
+	if d.passphrase != "" {
+		for _, entity := range d.keys {
+			if err := entity.DecryptPrivateKeys([]byte(d.passphrase)); err != nil {
+				d.err = fmt.Errorf("error unlocking key %X: %v", entity.PrimaryKey.Fingerprint, err)
+				return
+			}
+		}
+	}
+}
+
+// parses an armored or binary keyring and adds its keys
+func (d *openpgpDecryptor) add(data []byte) error {
+	var keys openpgp.EntityList
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armorPrefix)) {
+		keys, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return fmt.Errorf("error reading OpenPGP keys: %v", err)
+	}
+
+	d.keys = append(d.keys, keys...)
+	return nil
+}
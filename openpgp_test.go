@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// generates a new private key, returning it along with its armored (and, given a passphrase, locked)
+// serialization
+func testKey(t *testing.T, passphrase string) (*openpgp.Entity, string) {
+	entity, err := openpgp.NewEntity("syncret", "test", "syncret@example.com", nil)
+	if err != nil {
+		t.Fatalf("erred generating key: %v", err)
+	}
+	if passphrase != "" {
+		if err := entity.EncryptPrivateKeys([]byte(passphrase), nil); err != nil {
+			t.Fatalf("erred locking key: %v", err)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := armor.Encode(buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatalf("erred armoring key: %v", err)
+	}
+	if err := entity.SerializePrivateWithoutSigning(w, nil); err != nil {
+		t.Fatalf("erred serializing key: %v", err)
+	}
+	w.Close()
+	return entity, buf.String()
+}
+
+// encrypts a message to the given key, optionally armored
+func testMessage(t *testing.T, to *openpgp.Entity, message string, armored bool) []byte {
+	buf := new(bytes.Buffer)
+	var out io.WriteCloser = nopCloser{buf}
+	if armored {
+		w, err := armor.Encode(buf, "PGP MESSAGE", nil)
+		if err != nil {
+			t.Fatalf("erred armoring message: %v", err)
+		}
+		out = w
+	}
+
+	plaintext, err := openpgp.Encrypt(out, []*openpgp.Entity{to}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("erred encrypting message: %v", err)
+	}
+	plaintext.Write([]byte(message))
+	plaintext.Close()
+	out.Close()
+	return buf.Bytes()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func Test_openpgpDecryptor_Decrypt(t *testing.T) {
+	entity, key := testKey(t, "")
+	lockedEntity, lockedKey := testKey(t, "hunter2")
+	_, otherKey := testKey(t, "")
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		keyring string
+		data    []byte
+		want    []byte
+		wantErr bool
+	}{
+		{
+			"armored message, key from env",
+			map[string]string{openpgpKeyEnvVar: key},
+			"",
+			testMessage(t, entity, "thisisjoe", true),
+			[]byte("thisisjoe"),
+			false,
+		},
+		{
+			"binary message, key from keyring",
+			map[string]string{},
+			key,
+			testMessage(t, entity, "thisisjoe", false),
+			[]byte("thisisjoe"),
+			false,
+		},
+		{
+			"locked key with passphrase",
+			map[string]string{openpgpKeyEnvVar: lockedKey, openpgpPassphraseEnvVar: "hunter2"},
+			"",
+			testMessage(t, lockedEntity, "thisisjoe", true),
+			[]byte("thisisjoe"),
+			false,
+		},
+		{
+			"locked key with wrong passphrase",
+			map[string]string{openpgpKeyEnvVar: lockedKey, openpgpPassphraseEnvVar: "hunter3"},
+			"",
+			testMessage(t, lockedEntity, "thisisjoe", true),
+			nil,
+			true,
+		},
+		{
+			"wrong key",
+			map[string]string{openpgpKeyEnvVar: otherKey},
+			"",
+			testMessage(t, entity, "thisisjoe", true),
+			nil,
+			true,
+		},
+		{
+			"no keys",
+			map[string]string{},
+			"",
+			testMessage(t, entity, "thisisjoe", true),
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpdir := testDir(t)
+			defer os.RemoveAll(tmpdir)
+
+			if tt.keyring != "" {
+				p := path.Join(tmpdir, "keyring.asc")
+				if err := ioutil.WriteFile(p, []byte(tt.keyring), 0600); err != nil {
+					t.Fatalf("erred writing keyring to %v: %v", p, err)
+				}
+				tt.env[openpgpKeyringEnvVar] = p
+			}
+
+			p := path.Join(tmpdir, "secret.gpg")
+			if err := ioutil.WriteFile(p, tt.data, 0666); err != nil {
+				t.Fatalf("erred writing test data to %v: %v", p, err)
+			}
+
+			got, err := newOpenPGPDecryptor(tt.env).Decrypt(p)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("openpgpDecryptor.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("openpgpDecryptor.Decrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// calls work for each index below n, with at most parallel calls at once, and then done for each index
+// in order as soon as it and every index before it have worked; the first error stops any more work
+// from starting (and done from being called), unless keepGoing, and the error at the lowest index is
+// returned once the work already started is done
+func forEach(n, parallel int, keepGoing bool, work func(i int) error, done func(i int, err error)) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		finished = make([]bool, n)
+		errs     = make([]error, n)
+		next     int // the lowest index not yet done
+	)
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				if ctx.Err() != nil {
+					continue // already failed elsewhere
+				}
+				err := work(i)
+
+				mu.Lock()
+				finished[i], errs[i] = true, err
+				if err != nil && !keepGoing {
+					cancel()
+				}
+				for next < n && finished[next] && (errs[next] == nil || keepGoing) {
+					if done != nil {
+						done(next, errs[next])
+					}
+					next++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		select {
+		case indices <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indices)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_forEach(t *testing.T) {
+	tests := []struct {
+		name      string
+		n         int
+		parallel  int
+		keepGoing bool
+		fail      int // the index which fails, if any
+		wantDone  []int
+		wantErr   bool
+	}{
+		{"sequential", 4, 1, false, -1, []int{0, 1, 2, 3}, false},
+		{"parallel", 8, 3, false, -1, []int{0, 1, 2, 3, 4, 5, 6, 7}, false},
+		{"no parallelism is sequential", 2, 0, false, -1, []int{0, 1}, false},
+		{"nothing", 0, 3, false, -1, nil, false},
+		{"stops at failure", 4, 1, false, 1, []int{0}, true},
+		{"done stops at failure", 8, 3, false, 0, nil, true},
+		{"keeps going", 4, 1, true, 1, []int{0, 1, 2, 3}, true},
+		{"keeps going in parallel", 8, 3, true, 0, []int{0, 1, 2, 3, 4, 5, 6, 7}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var running, most int32
+			var done []int
+			err := forEach(tt.n, tt.parallel, tt.keepGoing, func(i int) error {
+				now := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					prev := atomic.LoadInt32(&most)
+					if now <= prev || atomic.CompareAndSwapInt32(&most, prev, now) {
+						break
+					}
+				}
+
+				// later indices finish first, to exercise ordering
+				time.Sleep(time.Duration(tt.n-i) * time.Millisecond)
+				if i == tt.fail {
+					return fmt.Errorf("failed %v", i)
+				}
+				return nil
+			}, func(i int, err error) {
+				if (err != nil) != (i == tt.fail) {
+					t.Errorf("forEach() done(%v) error = %v", i, err)
+				}
+				done = append(done, i)
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("forEach() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(done, tt.wantDone) {
+				t.Errorf("forEach() done = %v, want %v", done, tt.wantDone)
+			}
+			if limit := int32(tt.parallel); limit > 0 && most > limit {
+				t.Errorf("forEach() ran %v at once, want at most %v", most, limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dlclark/regexp2"
+)
+
+// how long to spend matching a value against its pattern, which backtracking can make take forever
+const patternTimeout = time.Second
+
+// checks each secret's value against its pattern, as the parameter store would on a put, returning the
+// secrets which pass, and why the rest don't. patterns the parameter store takes are Java regexes, with
+// lookarounds and backreferences, so they're matched with a backtracking engine much like Java's
+func checkPatterns(secrets []secret) ([]secret, failures) {
+	var valid []secret
+	invalid := make(failures)
+	for _, s := range secrets {
+		if err := checkPattern(s); err != nil {
+			invalid[s.Name] = err
+			continue
+		}
+		valid = append(valid, s)
+	}
+	return valid, invalid
+}
+
+func checkPattern(s secret) error {
+	if s.Pattern == "" {
+		return nil
+	}
+
+	re, err := regexp2.Compile(s.Pattern, regexp2.None)
+	if err != nil {
+		return fmt.Errorf("bad pattern %q: %v", s.Pattern, err)
+	}
+	re.MatchTimeout = patternTimeout
+
+	matched, err := re.MatchString(s.Value)
+	if err != nil {
+		// too slow to tell here; the parameter store will have its say on a put
+		log.Printf("Couldn't check %v against its pattern, leaving it to the parameter store: %v", s.Name, err)
+		return nil
+	}
+	if !matched {
+		// never the value itself
+		return fmt.Errorf("value doesn't match pattern %q", s.Pattern)
+	}
+	return nil
+}
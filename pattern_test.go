@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_checkPattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  secret
+		wantErr bool
+	}{
+		{"no pattern", secret{Name: "/a", Value: "anything"}, false},
+		{"matches", secret{Name: "/a", Value: "42", Pattern: `^\d+$`}, false},
+		{"matches part", secret{Name: "/a", Value: "postgres://db", Pattern: `postgres`}, false},
+		{"doesn't match", secret{Name: "/a", Value: "hunter2", Pattern: `^\d+$`}, true},
+		{"bad pattern", secret{Name: "/a", Value: "42", Pattern: `^(\d+$`}, true},
+		{"lookahead", secret{Name: "/a", Value: "hunter22", Pattern: `^(?=.*\d).{8,}$`}, false},
+		{"lookahead doesn't match", secret{Name: "/a", Value: "hunter", Pattern: `^(?=.*\d).{8,}$`}, true},
+		{"backreference", secret{Name: "/a", Value: "abab", Pattern: `^(ab)\1$`}, false},
+		{"too slow to tell", secret{Name: "/a", Value: strings.Repeat("a", 40) + "!", Pattern: `^(a+)+$`}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPattern(tt.secret)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkPattern() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), tt.secret.Value) {
+				t.Errorf("checkPattern() error = %v, leaks the value", err)
+			}
+		})
+	}
+}
+
+func Test_checkPatterns(t *testing.T) {
+	secrets := []secret{
+		{Name: "/a", Value: "1", Pattern: `^\d$`},
+		{Name: "/b", Value: "b", Pattern: `^\d$`},
+		{Name: "/c", Value: "c"},
+	}
+	valid, invalid := checkPatterns(secrets)
+	if want := []secret{secrets[0], secrets[2]}; !reflect.DeepEqual(valid, want) {
+		t.Errorf("checkPatterns() = %v, want %v", valid, want)
+	}
+	if !reflect.DeepEqual(invalid.names(), []string{"/b"}) {
+		t.Errorf("checkPatterns() failed %v, want [/b]", invalid.names())
+	}
+}
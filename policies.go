@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// an SSM parameter policy, as in the JSON SSM takes
+type policy struct {
+	Type       string            `json:"Type"`
+	Version    string            `json:"Version"`
+	Attributes map[string]string `json:"Attributes"`
+
+	relative bool // an expiration recomputed every run, from expire-after
+}
+
+// parses a .policies file: either SSM's own JSON, or lines of a simpler form, relative to now:
+//
+//	expire-after: 90d
+//	expire-at: 2030-01-01T00:00:00Z
+//	notify-before-expiry: 14d
+//	notify-unchanged-after: 30d
+//
+// durations are in days (d) or hours (h)
+func parsePolicies(data []byte, now time.Time) ([]policy, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	if data[0] == '[' {
+		var policies []policy
+		if err := json.Unmarshal(data, &policies); err != nil {
+			return nil, err
+		}
+		return policies, nil
+	}
+
+	var policies []policy
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad policy %q: should be name: value", line)
+		}
+		name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		var p policy
+		switch name {
+		case "expire-after":
+			n, unit, err := parsePolicyDuration(value)
+			if err != nil {
+				return nil, err
+			}
+			d := time.Duration(n) * time.Hour
+			if unit == "Days" {
+				d *= 24
+			}
+			p = expiration(now.Add(d))
+			p.relative = true
+		case "expire-at":
+			at, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("bad policy %q: %v", line, err)
+			}
+			p = expiration(at)
+		case "notify-before-expiry":
+			n, unit, err := parsePolicyDuration(value)
+			if err != nil {
+				return nil, err
+			}
+			p = policy{"ExpirationNotification", "1.0", map[string]string{"Before": strconv.Itoa(n), "Unit": unit}, false}
+		case "notify-unchanged-after":
+			n, unit, err := parsePolicyDuration(value)
+			if err != nil {
+				return nil, err
+			}
+			p = policy{"NoChangeNotification", "1.0", map[string]string{"After": strconv.Itoa(n), "Unit": unit}, false}
+		default:
+			return nil, fmt.Errorf("unknown policy %v", name)
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+func expiration(at time.Time) policy {
+	timestamp := at.UTC().Truncate(time.Second).Format(time.RFC3339)
+	return policy{"Expiration", "1.0", map[string]string{"Timestamp": timestamp}, false}
+}
+
+// 90d -> 90, Days; 12h -> 12, Hours
+func parsePolicyDuration(value string) (int, string, error) {
+	units := map[string]string{"d": "Days", "h": "Hours"}
+	if len(value) < 2 || units[value[len(value)-1:]] == "" {
+		return 0, "", fmt.Errorf("bad duration %q: should be a number of days (90d) or hours (12h)", value)
+	}
+
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n <= 0 {
+		return 0, "", fmt.Errorf("bad duration %q: should be a number of days (90d) or hours (12h)", value)
+	}
+	return n, units[value[len(value)-1:]], nil
+}
+
+// the Policies JSON for a put; empty if there are none
+func policiesJSON(policies []policy) string {
+	if len(policies) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(policies)
+	if err != nil {
+		// nothing but strings in there
+		panic(err)
+	}
+	return string(data)
+}
+
+// a form of Policies JSON fit for comparing, in order
+func comparablePolicies(text string) string {
+	var policies []policy
+	if err := json.Unmarshal([]byte(text), &policies); err != nil {
+		return text
+	}
+
+	sort.SliceStable(policies, func(i, j int) bool {
+		return policies[i].Type < policies[j].Type
+	})
+	return policiesJSON(policies)
+}
+
+// the input for a secret with relative expirations, which are recomputed every run, given the current
+// expiry instead; so they only move when the value is written anew
+func keepExpiry(current, input *ssm.PutParameterInput, secret secret) *ssm.PutParameterInput {
+	if current == nil {
+		return input
+	}
+
+	var existing []policy
+	if err := json.Unmarshal([]byte(aws.StringValue(current.Policies)), &existing); err != nil {
+		return input
+	}
+	var timestamp string
+	for _, p := range existing {
+		if p.Type == "Expiration" {
+			timestamp = p.Attributes["Timestamp"]
+		}
+	}
+	if timestamp == "" {
+		return input
+	}
+
+	var policies []policy
+	kept := false
+	for _, p := range secret.Policies {
+		if p.relative {
+			p.Attributes = map[string]string{"Timestamp": timestamp}
+			kept = true
+		}
+		policies = append(policies, p)
+	}
+	if !kept {
+		return input
+	}
+
+	withExpiry := *input
+	withExpiry.Policies = aws.String(policiesJSON(policies))
+	return &withExpiry
+}
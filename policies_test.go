@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func Test_parsePolicies(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		data    string
+		want    []policy
+		wantErr bool
+	}{
+		{"empty", "\n", nil, false},
+		{
+			"lines",
+			"# rotate quarterly\nexpire-after: 90d\n\nnotify-before-expiry: 14d\nnotify-unchanged-after: 12h\n",
+			[]policy{
+				{"Expiration", "1.0", map[string]string{"Timestamp": "2030-04-01T12:30:00Z"}, true},
+				{"ExpirationNotification", "1.0", map[string]string{"Before": "14", "Unit": "Days"}, false},
+				{"NoChangeNotification", "1.0", map[string]string{"After": "12", "Unit": "Hours"}, false},
+			},
+			false,
+		},
+		{
+			"expire at",
+			"expire-at: 2031-06-01T00:00:00+02:00",
+			[]policy{{"Expiration", "1.0", map[string]string{"Timestamp": "2031-05-31T22:00:00Z"}, false}},
+			false,
+		},
+		{
+			"json",
+			`[{"Type":"NoChangeNotification","Version":"1.0","Attributes":{"After":"30","Unit":"Days"}}]`,
+			[]policy{{"NoChangeNotification", "1.0", map[string]string{"After": "30", "Unit": "Days"}, false}},
+			false,
+		},
+		{"bad json", `[{"Type":}]`, nil, true},
+		{"unknown policy", "rotate-every: 90d", nil, true},
+		{"no colon", "expire-after 90d", nil, true},
+		{"bad unit", "expire-after: 90w", nil, true},
+		{"bad number", "notify-before-expiry: -1d", nil, true},
+		{"bad time", "expire-at: tomorrow", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePolicies([]byte(tt.data), now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parsePolicies() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePolicies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_comparablePolicies(t *testing.T) {
+	a := `[{"Type":"NoChangeNotification","Version":"1.0","Attributes":{"After":"30","Unit":"Days"}},` +
+		`{"Type":"Expiration","Version":"1.0","Attributes":{"Timestamp":"2030-01-01T00:00:00Z"}}]`
+	b := `[{"Type":"Expiration","Version":"1.0","Attributes":{"Timestamp":"2031-01-01T00:00:00Z"}},` +
+		`{"Type":"NoChangeNotification","Version":"1.0","Attributes":{"After":"30","Unit":"Days"}}]`
+	if comparablePolicies(a) == comparablePolicies(b) {
+		t.Errorf("comparablePolicies() = %v for both expirations", comparablePolicies(a))
+	}
+	c := `[{"Type":"Expiration","Version":"1.0","Attributes":{"Timestamp":"2030-01-01T00:00:00Z"}},` +
+		`{"Type":"NoChangeNotification","Version":"1.0","Attributes":{"After":"30","Unit":"Days"}}]`
+	if comparablePolicies(a) != comparablePolicies(c) {
+		t.Errorf("comparablePolicies() = %v, want %v", comparablePolicies(a), comparablePolicies(c))
+	}
+	if comparablePolicies("") != "" {
+		t.Errorf("comparablePolicies() = %v, want empty", comparablePolicies(""))
+	}
+}
+
+func Test_committer_Sync_expiry(t *testing.T) {
+	current := func() map[string]*ssm.PutParameterInput {
+		return map[string]*ssm.PutParameterInput{
+			"/expiring": makeInput(secret{
+				Name:     "/expiring",
+				Value:    "value",
+				Policies: []policy{expiration(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))},
+			}),
+		}
+	}
+	later := time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		secret     secret
+		want       action
+		wantExpiry string // of the put, if any
+	}{
+		{
+			"relative expiry alone isn't written",
+			secret{Name: "/expiring", Value: "value", Policies: []policy{relativeExpiration(later)}},
+			unchanged,
+			"",
+		},
+		{
+			"relative expiry is kept with other changes",
+			secret{Name: "/expiring", Value: "value", Description: "new", Policies: []policy{relativeExpiration(later)}},
+			updated,
+			"2030-01-01T00:00:00Z",
+		},
+		{
+			"relative expiry restarts with a new value",
+			secret{Name: "/expiring", Value: "new value", Policies: []policy{relativeExpiration(later)}},
+			updated,
+			"2030-06-01T00:00:00Z",
+		},
+		{
+			"absolute expiry is written",
+			secret{Name: "/expiring", Value: "value", Policies: []policy{expiration(later)}},
+			updated,
+			"2030-06-01T00:00:00Z",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &MockClient{
+				params: current(),
+				tags:   map[string]map[string]string{"/expiring": {managedByTag: managedByValue}},
+			}
+			got, err := (&committer{client}).Sync(tt.secret)
+			if err != nil {
+				t.Fatalf("committer.Sync() error = %v", err)
+			}
+			if got.Action != tt.want {
+				t.Errorf("committer.Sync() = %v, want %v", got.Action, tt.want)
+			}
+
+			var expiry string
+			if client.lastPut != nil {
+				var policies []policy
+				json.Unmarshal([]byte(aws.StringValue(client.lastPut.Policies)), &policies)
+				expiry = policies[0].Attributes["Timestamp"]
+			}
+			if expiry != tt.wantExpiry {
+				t.Errorf("committer.Sync() put expiry %v, want %v", expiry, tt.wantExpiry)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// return a new pruner which finds parameters under the given roots with no matching secret
+func newPruner(roots []string) pruner {
+	return &pathPruner{newClient(), roots}
+}
+
+// a pruner for the parameter store paths "managed" by syncret: anything under one of its roots
+// which wasn't loaded is considered deleted
+type pathPruner struct {
+	ssmiface.SSMAPI
+	roots []string
+}
+
+func (p *pathPruner) Prune(secrets []secret) ([]string, error) {
+	local := make(map[string]bool)
+	for _, secret := range secrets {
+		local[secret.Name] = true
+	}
+
+	var names []string
+	for _, root := range p.roots {
+		root = "/" + strings.Trim(root, "/")
+		if !anyUnder(root, secrets) {
+			// most likely a typo or an empty input; either way, deleting everything is not the answer
+			return nil, fmt.Errorf("refusing to prune %v: no secrets found under it", root)
+		}
+
+		remote, err := listNames(p, root)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range remote {
+			if !local[name] {
+				names = append(names, name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// a pruner for secrets whose files were deleted, unless they were loaded after all (e.g. renamed back)
+type deletedPruner []string
+
+func (p deletedPruner) Prune(secrets []secret) ([]string, error) {
+	local := make(map[string]bool)
+	for _, secret := range secrets {
+		local[secret.Name] = true
+	}
+
+	var names []string
+	for _, name := range p {
+		if !local[name] {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// lists the names of all parameters under the given path
+func listNames(api ssmiface.SSMAPI, root string) ([]string, error) {
+	var names []string
+	err := api.GetParametersByPathPages(&ssm.GetParametersByPathInput{
+		Path:      aws.String(root),
+		Recursive: aws.Bool(true),
+	}, func(page *ssm.GetParametersByPathOutput, lastPage bool) bool {
+		for _, param := range page.Parameters {
+			names = append(names, aws.StringValue(param.Name))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing %v: %v", root, err)
+	}
+	return names, nil
+}
+
+// GetParametersByPathPages, a call per page through get, so that wrapping clients can retry or limit each
+// page rather than the whole listing
+func pagesByPath(get func(*ssm.GetParametersByPathInput) (*ssm.GetParametersByPathOutput, error),
+	input *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool) error {
+	page := *input
+	for {
+		out, err := get(&page)
+		if err != nil {
+			return err
+		}
+		last := aws.StringValue(out.NextToken) == ""
+		if !fn(out, last) || last {
+			return nil
+		}
+		page.NextToken = out.NextToken
+	}
+}
+
+// whether any of the secrets' names are under the given path
+func anyUnder(root string, secrets []secret) bool {
+	for _, secret := range secrets {
+		if strings.HasPrefix(secret.Name, root+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func Test_pathPruner_Prune(t *testing.T) {
+	remote := map[string]*ssm.PutParameterInput{
+		"/prod/svc/KEPT":      {},
+		"/prod/svc/GONE":      {},
+		"/prod/svc/deep/GONE": {},
+		"/prod/other/KEY":     {},
+	}
+
+	tests := []struct {
+		name    string
+		client  *MockClient
+		roots   []string
+		secrets []secret
+		want    []string
+		wantErr bool
+	}{
+		{
+			"finds missing under root",
+			&MockClient{params: remote},
+			[]string{"/prod/svc"},
+			[]secret{{Name: "/prod/svc/KEPT"}},
+			[]string{"/prod/svc/GONE", "/prod/svc/deep/GONE"},
+			false,
+		},
+		{
+			"normalizes roots",
+			&MockClient{params: remote},
+			[]string{"prod/svc/"},
+			[]secret{{Name: "/prod/svc/KEPT"}, {Name: "/prod/svc/GONE"}},
+			[]string{"/prod/svc/deep/GONE"},
+			false,
+		},
+		{
+			"nothing to prune",
+			&MockClient{params: remote},
+			[]string{"/prod/other"},
+			[]secret{{Name: "/prod/other/KEY"}},
+			nil,
+			false,
+		},
+		{
+			"refuses root without local secrets",
+			&MockClient{params: remote},
+			[]string{"/prod/svc"},
+			[]secret{{Name: "/prod/other/KEY"}},
+			nil,
+			true,
+		},
+		{
+			"propagates error",
+			&MockClient{error: fmt.Errorf("my error")},
+			[]string{"/prod/svc"},
+			[]secret{{Name: "/prod/svc/KEPT"}},
+			nil,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &pathPruner{tt.client, tt.roots}
+			got, err := p.Prune(tt.secrets)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("pathPruner.Prune() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pathPruner.Prune() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_deletedPruner_Prune(t *testing.T) {
+	p := deletedPruner{"/renamed/back", "/gone"}
+	got, err := p.Prune([]secret{{Name: "/renamed/back"}})
+	if err != nil {
+		t.Fatalf("deletedPruner.Prune() error = %v", err)
+	}
+	if want := []string{"/gone"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deletedPruner.Prune() = %v, want %v", got, want)
+	}
+}
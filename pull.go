@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+const encryptEnvVar = "SYNCRET_ENCRYPT"
+
+// encrypts a value into the contents of a secret file
+type encryptor interface {
+	Encrypt(value []byte) ([]byte, error)
+}
+
+// an encryptor which runs a command, which is passed the value on stdin and writes it encrypted to stdout
+type execEncryptor struct {
+	cmd  string
+	args []string
+}
+
+func (e execEncryptor) Encrypt(value []byte) ([]byte, error) {
+	cmd := exec.Command(e.cmd, e.args...)
+	cmd.Stdin = bytes.NewReader(value)
+	cmd.Stderr = os.Stderr
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// writes the parameters under a path out as secret files and sidecars, just as the loader would read them
+type puller struct {
+	ssmiface.SSMAPI
+	encryptor encryptor // nil if there's no SYNCRET_ENCRYPT, in which case SecureStrings can't be pulled
+
+	secretSuffix, plaintextSuffix                                                       string
+	descriptionSuffix, patternSuffix, kmsSuffix, typeSuffix, tagsSuffix, policiesSuffix string
+
+	fsPrefix string
+	rootDir  string
+}
+
+func newPuller() (*puller, error) {
+	return doNewPuller(envMap(os.Environ()), *prefix, *rootDir, newClient())
+}
+
+func doNewPuller(env map[string]string, prefix, rootDir string, client ssmiface.SSMAPI) (*puller, error) {
+	p := &puller{
+		SSMAPI:            client,
+		secretSuffix:      envSuffix(env, secretEnvVar),
+		plaintextSuffix:   envSuffix(env, plaintextEnvVar),
+		descriptionSuffix: envSuffix(env, descriptionEnvVar),
+		patternSuffix:     envSuffix(env, patternEnvVar),
+		kmsSuffix:         envSuffix(env, kmsEnvVar),
+		typeSuffix:        envSuffix(env, typeEnvVar),
+		tagsSuffix:        envSuffix(env, tagsEnvVar),
+		policiesSuffix:    envSuffix(env, policiesEnvVar),
+		fsPrefix:          prefix,
+		rootDir:           rootDir,
+	}
+
+	if method, ok := env[encryptEnvVar]; ok {
+		cmd, args := splitCommand(method)
+		if cmd == "" {
+			return nil, fmt.Errorf("bad %v: empty encryption command", encryptEnvVar)
+		}
+		p.encryptor = execEncryptor{cmd, args}
+	}
+	return p, nil
+}
+
+// pulls every parameter under a parameter store path
+func (p *puller) Pull(root string) error {
+	root = "/" + strings.Trim(root, "/")
+	names, err := listNames(p, root)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		param, _, err := fetch(p, name)
+		if err != nil {
+			return err
+		}
+		if param == nil {
+			// deleted since it was listed
+			continue
+		}
+
+		if err := p.write(param); err != nil {
+			return fmt.Errorf("failed pulling %v: %v", name, err)
+		}
+		log.Printf("Pulled %v", name)
+	}
+	return nil
+}
+
+// writes out a parameter's files, without overwriting any
+func (p *puller) write(param *ssm.PutParameterInput) error {
+	name, value := aws.StringValue(param.Name), aws.StringValue(param.Value)
+	s := p.path(name)
+
+	type file struct {
+		name string
+		data []byte
+	}
+	var files []file
+	switch aws.StringValue(param.Type) {
+	case ssm.ParameterTypeSecureString:
+		if p.encryptor == nil {
+			return fmt.Errorf("it's a SecureString, but %v isn't set", encryptEnvVar)
+		}
+		encrypted, err := p.encryptor.Encrypt([]byte(value))
+		if err != nil {
+			return fmt.Errorf("failed encrypting: %v", err)
+		}
+		files = append(files, file{s + p.secretSuffix, encrypted})
+
+		if keyID := aws.StringValue(param.KeyId); keyID != "" && keyID != defaultKeyID {
+			files = append(files, file{s + p.kmsSuffix, []byte(keyID)})
+		}
+	case ssm.ParameterTypeStringList:
+		files = append(files, file{s + p.plaintextSuffix, []byte(value)})
+		files = append(files, file{s + p.typeSuffix, []byte(ssm.ParameterTypeStringList)})
+	default:
+		// plaintext files are Strings to begin with
+		files = append(files, file{s + p.plaintextSuffix, []byte(value)})
+	}
+
+	if description := aws.StringValue(param.Description); description != "" {
+		files = append(files, file{s + p.descriptionSuffix, []byte(description)})
+	}
+	if pattern := aws.StringValue(param.AllowedPattern); pattern != "" {
+		files = append(files, file{s + p.patternSuffix, []byte(pattern)})
+	}
+	if policies := aws.StringValue(param.Policies); policies != "" {
+		files = append(files, file{s + p.policiesSuffix, []byte(policies)})
+	}
+
+	tags, err := listTags(p, name)
+	if err != nil {
+		return err
+	}
+	var lines []string
+	for _, key := range sortedKeys(tags) {
+		if key != managedByTag {
+			lines = append(lines, key+"="+tags[key]+"\n")
+		}
+	}
+	if len(lines) > 0 {
+		files = append(files, file{s + p.tagsSuffix, []byte(strings.Join(lines, ""))})
+	}
+
+	if strings.TrimRightFunc(value, unicode.IsSpace) != value {
+		log.Printf("%v ends in whitespace, which is trimmed unless syncing with -trim=false", name)
+	}
+
+	for _, f := range files {
+		if _, err := os.Stat(resolve(p.rootDir, f.name)); err == nil {
+			return fmt.Errorf("%v already exists", f.name)
+		}
+	}
+	for _, f := range files {
+		full := resolve(p.rootDir, f.name)
+		if err := os.MkdirAll(path.Dir(full), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(full, f.data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the unextended path of a parameter: the reverse of the loader's name
+func (p *puller) path(name string) string {
+	if p.fsPrefix == "" || strings.HasSuffix(p.fsPrefix, "/") {
+		return p.fsPrefix + strings.TrimPrefix(name, "/")
+	}
+	return p.fsPrefix + name
+}
+
+func pullCmd(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no paths to pull")
+	}
+
+	p, err := newPuller()
+	if err != nil {
+		return err
+	}
+	for _, root := range args {
+		if err := p.Pull(root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
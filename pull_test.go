@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func Test_puller_Pull(t *testing.T) {
+	secrets := []secret{
+		{Name: "/prod/app/DB_URL", Value: "postgres://db", Description: "the db", Pattern: "^postgres://"},
+		{Name: "/prod/app/KEY", Value: "hunter2", KeyID: "alias/prod"},
+		{Name: "/prod/app/HOSTS", Value: "a,b", Type: ssm.ParameterTypeStringList},
+		{Name: "/prod/app/URL", Value: "https://example.com", Type: ssm.ParameterTypeString},
+		{
+			Name:     "/prod/app/EXPIRING",
+			Value:    "soon",
+			Policies: []policy{expiration(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))},
+		},
+		{Name: "/staging/app/KEY", Value: "elsewhere"},
+	}
+	params := make(map[string]*ssm.PutParameterInput)
+	for _, s := range secrets {
+		params[s.Name] = makeInput(s)
+	}
+	// once Advanced, always Advanced
+	params["/prod/app/URL"].Tier = aws.String(ssm.ParameterTierAdvanced)
+	// as if synced before; otherwise the first apply would only add the managed-by tag
+	tags := make(map[string]map[string]string)
+	for name := range params {
+		tags[name] = map[string]string{managedByTag: managedByValue}
+	}
+	tags["/prod/app/KEY"] = map[string]string{managedByTag: managedByValue, "team": "payments", "env": "prod"}
+	client := &MockClient{params: params, tags: tags}
+
+	tmpdir := testDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	p, err := doNewPuller(map[string]string{encryptEnvVar: "cat"}, "secrets/", tmpdir, client)
+	if err != nil {
+		t.Fatalf("doNewPuller() error = %v", err)
+	}
+	if err := p.Pull("/prod/"); err != nil {
+		t.Fatalf("puller.Pull() error = %v", err)
+	}
+
+	var paths []string
+	filepath.Walk(tmpdir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(tmpdir, p)
+			paths = append(paths, rel)
+		}
+		return err
+	})
+	sort.Strings(paths)
+	want := []string{
+		"secrets/prod/app/DB_URL.description",
+		"secrets/prod/app/DB_URL.gpg",
+		"secrets/prod/app/DB_URL.pattern",
+		"secrets/prod/app/EXPIRING.gpg",
+		"secrets/prod/app/EXPIRING.policies",
+		"secrets/prod/app/HOSTS.plain",
+		"secrets/prod/app/HOSTS.type",
+		"secrets/prod/app/KEY.gpg",
+		"secrets/prod/app/KEY.kms",
+		"secrets/prod/app/KEY.tags",
+		"secrets/prod/app/URL.plain",
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("puller.Pull() wrote %v, want %v", paths, want)
+	}
+
+	written, _ := ioutil.ReadFile(filepath.Join(tmpdir, "secrets/prod/app/KEY.tags"))
+	if string(written) != "env=prod\nteam=payments\n" {
+		t.Errorf("puller.Pull() wrote tags %q", written)
+	}
+
+	// syncing what was pulled should change nothing
+	l := fsLoader{
+		decryptors:        map[string]decryptor{".gpg": fakeDecryptor{}, ".plain": plaintextDecryptor{}},
+		descriptionSuffix: ".description",
+		patternSuffix:     ".pattern",
+		kmsSuffix:         ".kms",
+		typeSuffix:        ".type",
+		tagsSuffix:        ".tags",
+		policiesSuffix:    ".policies",
+		fsPrefix:          "secrets/",
+		rootDir:           tmpdir,
+		trim:              true,
+	}
+	loaded, err := l.LoadAll(paths)
+	if err != nil {
+		t.Fatalf("fsLoader.LoadAll() error = %v", err)
+	}
+	if len(loaded) != 5 {
+		t.Errorf("fsLoader.LoadAll() loaded %d secrets, want 5", len(loaded))
+	}
+	d := &differ{client, new(bytes.Buffer)}
+	for _, s := range loaded {
+		if r, err := d.Sync(s); err != nil || r.Action != unchanged {
+			t.Errorf("differ.Sync(%v) = %v, %v; want unchanged", s.Name, r.Action, err)
+		}
+		if s.Name == "/prod/app/KEY" && !reflect.DeepEqual(s.Tags, map[string]string{"team": "payments", "env": "prod"}) {
+			t.Errorf("fsLoader.LoadAll() tagged %v with %v", s.Name, s.Tags)
+		}
+	}
+
+	// and pulling again shouldn't overwrite anything
+	if err := p.Pull("/prod"); err == nil {
+		t.Errorf("puller.Pull() again didn't fail")
+	}
+}
+
+func Test_puller_Pull_noEncryptor(t *testing.T) {
+	client := &MockClient{params: map[string]*ssm.PutParameterInput{
+		"/prod/KEY": makeInput(secret{Name: "/prod/KEY", Value: "hunter2"}),
+	}}
+
+	tmpdir := testDir(t)
+	defer os.RemoveAll(tmpdir)
+
+	p, err := doNewPuller(map[string]string{}, "", tmpdir, client)
+	if err != nil {
+		t.Fatalf("doNewPuller() error = %v", err)
+	}
+	if err := p.Pull("/prod"); err == nil {
+		t.Errorf("puller.Pull() of a SecureString without %v didn't fail", encryptEnvVar)
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, "prod/KEY.gpg")); !os.IsNotExist(err) {
+		t.Errorf("puller.Pull() wrote prod/KEY.gpg anyway")
+	}
+}
+
+func Test_puller_path(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"", "prod/app/KEY"},
+		{"secrets/", "secrets/prod/app/KEY"},
+		{"secrets", "secrets/prod/app/KEY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			p := &puller{fsPrefix: tt.prefix}
+			got := p.path("/prod/app/KEY")
+			if got != tt.want {
+				t.Errorf("puller.path() = %v, want %v", got, tt.want)
+			}
+
+			// which the loader names right back
+			if name, err := (fsLoader{fsPrefix: tt.prefix}).name(got); err != nil || name != "/prod/app/KEY" {
+				t.Errorf("fsLoader.name(%v) = %v, %v", got, name, err)
+			}
+		})
+	}
+}
+
+func Test_execEncryptor_Encrypt(t *testing.T) {
+	got, err := execEncryptor{"tr", []string{"a-z", "A-Z"}}.Encrypt([]byte("hunter2"))
+	if err != nil {
+		t.Fatalf("execEncryptor.Encrypt() error = %v", err)
+	}
+	if string(got) != "HUNTER2" {
+		t.Errorf("execEncryptor.Encrypt() = %v, want HUNTER2", string(got))
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	reportFormat = flag.String("report", "", "Write a report of every secret's outcome at the end of a run: json or junit")
+	reportFile   = flag.String("report-file", "", "The file -report writes to")
+)
+
+// given every outcome of a run, reports on them
+type reporter interface {
+	Report(outcomes []outcome) error
+}
+
+// the reporter for a format, writing to the named file
+func newReporter(format, fname string) (reporter, error) {
+	if fname == "" {
+		return nil, fmt.Errorf("-report needs a -report-file")
+	}
+
+	switch format {
+	case "json":
+		return fileReporter{fname, writeJSONReport}, nil
+	case "junit":
+		return fileReporter{fname, writeJUnitReport}, nil
+	default:
+		return nil, fmt.Errorf("unknown report format %v", format)
+	}
+}
+
+// writes a report to a file
+type fileReporter struct {
+	fname string
+	write func(w io.Writer, outcomes []outcome) error
+}
+
+func (r fileReporter) Report(outcomes []outcome) error {
+	f, err := os.Create(r.fname)
+	if err != nil {
+		return fmt.Errorf("failed writing report: %v", err)
+	}
+	if err := r.write(f, outcomes); err != nil {
+		f.Close()
+		return fmt.Errorf("failed writing report: %v", err)
+	}
+	return f.Close()
+}
+
+// one line of the JSON report; there's nowhere for a value to go
+type jsonOutcome struct {
+	Name     string  `json:"name"`
+	Action   action  `json:"action"`
+	Duration float64 `json:"duration"` // in seconds
+	Error    string  `json:"error,omitempty"`
+	Version  int64   `json:"version,omitempty"`
+}
+
+func writeJSONReport(w io.Writer, outcomes []outcome) error {
+	report := struct {
+		Secrets []jsonOutcome `json:"secrets"`
+	}{[]jsonOutcome{}}
+
+	for _, o := range outcomes {
+		report.Secrets = append(report.Secrets, jsonOutcome{
+			Name:     o.name,
+			Action:   o.Action,
+			Duration: o.duration.Seconds(),
+			Error:    errorString(o.err),
+			Version:  o.Version,
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+// one test case per secret, named for it, with what was done in its output
+func writeJUnitReport(w io.Writer, outcomes []outcome) error {
+	suite := junitSuite{Name: "syncret", Tests: len(outcomes)}
+	for _, o := range outcomes {
+		c := junitCase{ClassName: "syncret", Name: o.name, Time: o.duration.Seconds()}
+		if o.err != nil {
+			suite.Failures++
+			c.Failure = &junitFailure{o.err.Error()}
+		} else if o.Version != 0 {
+			c.SystemOut = fmt.Sprintf("%v version %d", o.Action, o.Version)
+		} else {
+			c.SystemOut = string(o.Action)
+		}
+		suite.Time += c.Time
+		suite.Cases = append(suite.Cases, c)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// an error without the value of the secret it's about, in case the error repeats it
+func redact(err error, value string) error {
+	if err == nil || value == "" || !strings.Contains(err.Error(), value) {
+		return err
+	}
+	return fmt.Errorf("%v", strings.Replace(err.Error(), value, "[redacted]", -1))
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
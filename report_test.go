@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var testOutcomes = []outcome{
+	{"/prod/new", result{Action: created, Version: 1}, 1500 * time.Millisecond, nil},
+	{"/prod/same", result{Action: unchanged}, 250 * time.Millisecond, nil},
+	{"/prod/bad", result{Action: failed}, 0, fmt.Errorf("bad pattern")},
+	{"/prod/gone", result{Action: deleted}, 0, nil},
+}
+
+func Test_writeJSONReport(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := writeJSONReport(buf, testOutcomes); err != nil {
+		t.Fatalf("writeJSONReport() error = %v", err)
+	}
+
+	want := `{
+  "secrets": [
+    {
+      "name": "/prod/new",
+      "action": "create",
+      "duration": 1.5,
+      "version": 1
+    },
+    {
+      "name": "/prod/same",
+      "action": "unchanged",
+      "duration": 0.25
+    },
+    {
+      "name": "/prod/bad",
+      "action": "failed",
+      "duration": 0,
+      "error": "bad pattern"
+    },
+    {
+      "name": "/prod/gone",
+      "action": "delete",
+      "duration": 0
+    }
+  ]
+}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("writeJSONReport() = %v, want %v", got, want)
+	}
+}
+
+func Test_writeJUnitReport(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := writeJUnitReport(buf, testOutcomes); err != nil {
+		t.Fatalf("writeJUnitReport() error = %v", err)
+	}
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuite name="syncret" tests="4" failures="1" time="1.75">
+  <testcase classname="syncret" name="/prod/new" time="1.5">
+    <system-out>create version 1</system-out>
+  </testcase>
+  <testcase classname="syncret" name="/prod/same" time="0.25">
+    <system-out>unchanged</system-out>
+  </testcase>
+  <testcase classname="syncret" name="/prod/bad" time="0">
+    <failure message="bad pattern"></failure>
+  </testcase>
+  <testcase classname="syncret" name="/prod/gone" time="0">
+    <system-out>delete</system-out>
+  </testcase>
+</testsuite>
+`
+	if got := buf.String(); got != want {
+		t.Errorf("writeJUnitReport() = %v, want %v", got, want)
+	}
+}
+
+func Test_newReporter(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		fname   string
+		wantErr bool
+	}{
+		{"json", "json", "report.json", false},
+		{"junit", "junit", "report.xml", false},
+		{"unknown format", "yaml", "report.yaml", true},
+		{"no file", "json", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newReporter(tt.format, tt.fname); (err != nil) != tt.wantErr {
+				t.Errorf("newReporter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_redact(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		value string
+		want  string
+	}{
+		{"removes value", fmt.Errorf("hunter2 doesn't match ^a"), "hunter2", "[redacted] doesn't match ^a"},
+		{"leaves others", fmt.Errorf("throttled"), "hunter2", "throttled"},
+		{"empty value", fmt.Errorf("throttled"), "", "throttled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redact(tt.err, tt.value); got.Error() != tt.want {
+				t.Errorf("redact() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
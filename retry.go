@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+var (
+	retries   = flag.Int("retries", 5, "How many times to retry a throttled or failed parameter store call")
+	retryBase = flag.Duration("retry-base", 200*time.Millisecond, "The delay before the first retry, doubling with each one")
+	retryMax  = flag.Duration("retry-max", 20*time.Second, "The longest delay between retries")
+
+	// the error codes AWS uses when it's throttling requests
+	throttlingCodes = map[string]bool{
+		"Throttling":                true,
+		"ThrottlingException":       true,
+		"RequestLimitExceeded":      true,
+		"TooManyRequestsException":  true,
+		ssm.ErrCodeTooManyUpdates:   true,
+		"RequestThrottledException": true,
+	}
+)
+
+// retries calls which fail retryably, with exponential backoff and full jitter
+type backoff struct {
+	retries int
+	base    time.Duration
+	max     time.Duration
+	sleep   func(time.Duration) // time.Sleep, but not in tests
+}
+
+// a backoff configured by CLI flags
+func newBackoff() backoff {
+	return backoff{*retries, *retryBase, *retryMax, time.Sleep}
+}
+
+// calls fn until it succeeds, fails fatally, or runs out of retries; op describes it in logs
+func (b backoff) do(op string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !retryable(err) || attempt >= b.retries {
+			return err
+		}
+
+		delay := b.delay(attempt)
+		log.Printf("Retrying %v in %v (retry %d of %d): %v", op, delay, attempt+1, b.retries, err)
+		b.sleep(delay)
+	}
+}
+
+// a random delay of up to base * 2^attempt, capped at max
+func (b backoff) delay(attempt int) time.Duration {
+	ceiling := b.base
+	for i := 0; i < attempt && ceiling < b.max; i++ {
+		ceiling *= 2
+	}
+	if ceiling > b.max {
+		ceiling = b.max
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// whether an AWS error is worth retrying: throttling, server errors and what the SDK would retry itself
+// (failures to send, like a connection reset, and timeouts) are, anything else is fatal
+func retryable(err error) bool {
+	if request.IsErrorRetryable(err) {
+		return true
+	}
+	if rerr, ok := err.(awserr.RequestFailure); ok {
+		if rerr.StatusCode() >= http.StatusInternalServerError || rerr.StatusCode() == http.StatusTooManyRequests {
+			return true
+		}
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		return throttlingCodes[aerr.Code()]
+	}
+	return false
+}
+
+// an SSM client which retries the calls syncret makes
+type retryingClient struct {
+	ssmiface.SSMAPI
+	retry backoff
+}
+
+func (c retryingClient) GetParameter(input *ssm.GetParameterInput) (out *ssm.GetParameterOutput, err error) {
+	err = c.retry.do("GetParameter "+aws.StringValue(input.Name), func() (err error) {
+		out, err = c.SSMAPI.GetParameter(input)
+		return err
+	})
+	return out, err
+}
+
+func (c retryingClient) DescribeParameters(input *ssm.DescribeParametersInput) (out *ssm.DescribeParametersOutput, err error) {
+	err = c.retry.do("DescribeParameters", func() (err error) {
+		out, err = c.SSMAPI.DescribeParameters(input)
+		return err
+	})
+	return out, err
+}
+
+func (c retryingClient) GetParametersByPath(input *ssm.GetParametersByPathInput) (out *ssm.GetParametersByPathOutput, err error) {
+	err = c.retry.do("GetParametersByPath "+aws.StringValue(input.Path), func() (err error) {
+		out, err = c.SSMAPI.GetParametersByPath(input)
+		return err
+	})
+	return out, err
+}
+
+func (c retryingClient) GetParametersByPathPages(input *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool) error {
+	return pagesByPath(c.GetParametersByPath, input, fn)
+}
+
+func (c retryingClient) PutParameter(input *ssm.PutParameterInput) (out *ssm.PutParameterOutput, err error) {
+	err = c.retry.do("PutParameter "+aws.StringValue(input.Name), func() (err error) {
+		out, err = c.SSMAPI.PutParameter(input)
+		return err
+	})
+	return out, err
+}
+
+func (c retryingClient) DeleteParameter(input *ssm.DeleteParameterInput) (out *ssm.DeleteParameterOutput, err error) {
+	err = c.retry.do("DeleteParameter "+aws.StringValue(input.Name), func() (err error) {
+		out, err = c.SSMAPI.DeleteParameter(input)
+		return err
+	})
+	return out, err
+}
+
+func (c retryingClient) ListTagsForResource(input *ssm.ListTagsForResourceInput) (out *ssm.ListTagsForResourceOutput, err error) {
+	err = c.retry.do("ListTagsForResource "+aws.StringValue(input.ResourceId), func() (err error) {
+		out, err = c.SSMAPI.ListTagsForResource(input)
+		return err
+	})
+	return out, err
+}
+
+func (c retryingClient) AddTagsToResource(input *ssm.AddTagsToResourceInput) (out *ssm.AddTagsToResourceOutput, err error) {
+	err = c.retry.do("AddTagsToResource "+aws.StringValue(input.ResourceId), func() (err error) {
+		out, err = c.SSMAPI.AddTagsToResource(input)
+		return err
+	})
+	return out, err
+}
+
+func (c retryingClient) RemoveTagsFromResource(input *ssm.RemoveTagsFromResourceInput) (out *ssm.RemoveTagsFromResourceOutput, err error) {
+	err = c.retry.do("RemoveTagsFromResource "+aws.StringValue(input.ResourceId), func() (err error) {
+		out, err = c.SSMAPI.RemoveTagsFromResource(input)
+		return err
+	})
+	return out, err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func Test_retryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"throttling", awserr.New("ThrottlingException", "slow down", nil), true},
+		{"too many updates", awserr.New(ssm.ErrCodeTooManyUpdates, "slow down", nil), true},
+		{"server error", awserr.NewRequestFailure(awserr.New("InternalServerError", "oops", nil), 503, "id"), true},
+		{"client error", awserr.NewRequestFailure(awserr.New("ValidationException", "bad", nil), 400, "id"), false},
+		{"not found", awserr.New(ssm.ErrCodeParameterNotFound, "not found", nil), false},
+		{"connection reset", awserr.New("RequestError", "send request failed", syscall.ECONNRESET), true},
+		{"timeout", awserr.New(request.ErrCodeResponseTimeout, "read timed out", nil), true},
+		{"broken pipe", awserr.New(request.ErrCodeRead, "read failed", fmt.Errorf("write: broken pipe")), true},
+		{"not aws", fmt.Errorf("my error"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_backoff_delay(t *testing.T) {
+	b := backoff{base: 100 * time.Millisecond, max: time.Second}
+	for attempt, ceiling := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		for i := 0; i < 20; i++ {
+			if got := b.delay(attempt); got < 0 || got >= ceiling*time.Millisecond {
+				t.Errorf("backoff.delay(%v) = %v, want under %v", attempt, got, ceiling*time.Millisecond)
+			}
+		}
+	}
+}
+
+func Test_retryingClient(t *testing.T) {
+	throttled := awserr.New("ThrottlingException", "slow down", nil)
+
+	tests := []struct {
+		name       string
+		client     *MockClient
+		retries    int
+		wantPuts   []string
+		wantSleeps int
+		wantErr    bool
+	}{
+		{
+			"retries throttling",
+			&MockClient{failures: []error{throttled, throttled}},
+			5,
+			[]string{"/new"},
+			2,
+			false,
+		},
+		{
+			"gives up after retries",
+			&MockClient{failures: []error{throttled, throttled, throttled}},
+			2,
+			nil,
+			2,
+			true,
+		},
+		{
+			"fatal errors aren't retried",
+			&MockClient{failures: []error{awserr.New("ValidationException", "bad", nil)}},
+			5,
+			nil,
+			0,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sleeps int
+			retry := backoff{tt.retries, time.Millisecond, time.Second, func(time.Duration) { sleeps++ }}
+			c := &committer{retryingClient{tt.client, retry}}
+
+			if _, err := c.Sync(secret{Name: "/new"}); (err != nil) != tt.wantErr {
+				t.Errorf("committer.Sync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(tt.client.puts, tt.wantPuts) {
+				t.Errorf("committer.Sync() put %v, want %v", tt.client.puts, tt.wantPuts)
+			}
+			if sleeps != tt.wantSleeps {
+				t.Errorf("committer.Sync() retried %v times, want %v", sleeps, tt.wantSleeps)
+			}
+		})
+	}
+}
+
+func Test_retryingClient_listNames(t *testing.T) {
+	throttled := awserr.New("ThrottlingException", "slow down", nil)
+	client := &MockClient{
+		params: map[string]*ssm.PutParameterInput{"/prod/A": {}, "/prod/B": {}, "/prod/C": {}},
+		// the first page is fine, then the second is throttled twice
+		failures: []error{nil, throttled, throttled},
+	}
+
+	var sleeps int
+	retry := backoff{5, time.Millisecond, time.Second, func(time.Duration) { sleeps++ }}
+	api := retryingClient{client, retry}
+
+	names, err := listNames(api, "/prod")
+	if err != nil {
+		t.Fatalf("listNames() error = %v", err)
+	}
+	if want := []string{"/prod/A", "/prod/B", "/prod/C"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("listNames() = %v, want %v", names, want)
+	}
+	if sleeps != 2 {
+		t.Errorf("listNames() retried %v times, want 2", sleeps)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+// return a new syncer which commits values to the secrets manager api
+func newSecretsManagerCommitter() syncer {
+	return &secretsManagerCommitter{secretsmanager.New(session.Must(session.NewSession()))}
+}
+
+// commits secrets to secrets manager rather than the parameter store; descriptions carry over, but
+// patterns have no equivalent and are ignored
+type secretsManagerCommitter struct {
+	secretsmanageriface.SecretsManagerAPI
+}
+
+func (s *secretsManagerCommitter) Sync(secret secret) (result, error) {
+	id := secretID(secret.Name)
+	current, err := s.DescribeSecret(&secretsmanager.DescribeSecretInput{SecretId: id})
+	if isNotFound(err) {
+		if _, err := s.CreateSecret(&secretsmanager.CreateSecretInput{
+			Name:         id,
+			Description:  aws.String(secret.Description),
+			SecretString: aws.String(secret.Value),
+		}); err != nil {
+			return result{}, fmt.Errorf("failed creating %v: %v", secret.Name, err)
+		}
+		return result{Action: created}, nil
+	} else if err != nil {
+		return result{}, fmt.Errorf("failed describing %v: %v", secret.Name, err)
+	}
+
+	// scheduled for deletion, but back again; it has to be restored before it can be changed
+	if current.DeletedDate != nil {
+		if _, err := s.RestoreSecret(&secretsmanager.RestoreSecretInput{SecretId: id}); err != nil {
+			return result{}, fmt.Errorf("failed restoring %v: %v", secret.Name, err)
+		}
+	}
+
+	valueChanged := true
+	value, err := s.GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: id})
+	if err == nil {
+		valueChanged = aws.StringValue(value.SecretString) != secret.Value
+	} else if !isNotFound(err) { // i.e. there's no current version
+		return result{}, fmt.Errorf("failed fetching %v: %v", secret.Name, err)
+	}
+	descriptionChanged := aws.StringValue(current.Description) != secret.Description
+
+	if !valueChanged && !descriptionChanged && current.DeletedDate == nil {
+		return result{Action: unchanged}, nil
+	}
+
+	if descriptionChanged {
+		if _, err := s.UpdateSecret(&secretsmanager.UpdateSecretInput{
+			SecretId:    id,
+			Description: aws.String(secret.Description),
+		}); err != nil {
+			return result{}, fmt.Errorf("failed updating %v: %v", secret.Name, err)
+		}
+	}
+
+	if valueChanged {
+		if _, err := s.PutSecretValue(&secretsmanager.PutSecretValueInput{
+			SecretId:     id,
+			SecretString: aws.String(secret.Value),
+		}); err != nil {
+			return result{}, fmt.Errorf("failed uploading %v: %v", secret.Name, err)
+		}
+	}
+
+	return result{Action: updated}, nil
+}
+
+// schedules the secret for deletion, after secrets manager's default recovery window
+func (s *secretsManagerCommitter) Delete(name string) error {
+	if _, err := s.DeleteSecret(&secretsmanager.DeleteSecretInput{SecretId: secretID(name)}); err != nil {
+		if isNotFound(err) {
+			return nil // already gone
+		}
+		return fmt.Errorf("failed deleting %v: %v", name, err)
+	}
+
+	return nil
+}
+
+// secrets manager names are conventionally relative, so /prod/my-service/KEY -> prod/my-service/KEY
+func secretID(name string) *string {
+	return aws.String(strings.TrimPrefix(name, "/"))
+}
+
+func isNotFound(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException
+}
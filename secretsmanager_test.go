@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+type mockSecret struct {
+	value       *string
+	description string
+	deleted     bool
+}
+
+type MockSecretsManagerClient struct {
+	secretsmanageriface.SecretsManagerAPI
+	error   error
+	secrets map[string]*mockSecret
+	calls   []string
+}
+
+func (c *MockSecretsManagerClient) lookup(id *string) (*mockSecret, error) {
+	if c.error != nil {
+		return nil, c.error
+	}
+	s, ok := c.secrets[*id]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "not found", nil)
+	}
+	return s, nil
+}
+
+func (c *MockSecretsManagerClient) DescribeSecret(input *secretsmanager.DescribeSecretInput) (*secretsmanager.DescribeSecretOutput, error) {
+	s, err := c.lookup(input.SecretId)
+	if err != nil {
+		return nil, err
+	}
+	out := &secretsmanager.DescribeSecretOutput{Name: input.SecretId, Description: aws.String(s.description)}
+	if s.deleted {
+		out.DeletedDate = aws.Time(time.Now())
+	}
+	return out, nil
+}
+
+func (c *MockSecretsManagerClient) GetSecretValue(input *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	s, err := c.lookup(input.SecretId)
+	if err != nil {
+		return nil, err
+	}
+	if s.value == nil {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "no current version", nil)
+	}
+	return &secretsmanager.GetSecretValueOutput{Name: input.SecretId, SecretString: s.value}, nil
+}
+
+func (c *MockSecretsManagerClient) CreateSecret(input *secretsmanager.CreateSecretInput) (*secretsmanager.CreateSecretOutput, error) {
+	c.calls = append(c.calls, "create "+*input.Name)
+	return &secretsmanager.CreateSecretOutput{}, nil
+}
+
+func (c *MockSecretsManagerClient) RestoreSecret(input *secretsmanager.RestoreSecretInput) (*secretsmanager.RestoreSecretOutput, error) {
+	c.calls = append(c.calls, "restore "+*input.SecretId)
+	return &secretsmanager.RestoreSecretOutput{}, nil
+}
+
+func (c *MockSecretsManagerClient) UpdateSecret(input *secretsmanager.UpdateSecretInput) (*secretsmanager.UpdateSecretOutput, error) {
+	c.calls = append(c.calls, "update "+*input.SecretId)
+	return &secretsmanager.UpdateSecretOutput{}, nil
+}
+
+func (c *MockSecretsManagerClient) PutSecretValue(input *secretsmanager.PutSecretValueInput) (*secretsmanager.PutSecretValueOutput, error) {
+	c.calls = append(c.calls, "put "+*input.SecretId)
+	return &secretsmanager.PutSecretValueOutput{}, nil
+}
+
+func (c *MockSecretsManagerClient) DeleteSecret(input *secretsmanager.DeleteSecretInput) (*secretsmanager.DeleteSecretOutput, error) {
+	if _, err := c.lookup(input.SecretId); err != nil {
+		return nil, err
+	}
+	c.calls = append(c.calls, "delete "+*input.SecretId)
+	return &secretsmanager.DeleteSecretOutput{}, nil
+}
+
+func Test_secretsManagerCommitter_Sync(t *testing.T) {
+	existing := func() map[string]*mockSecret {
+		return map[string]*mockSecret{
+			"prod/same":    {value: aws.String("value"), description: "desc"},
+			"prod/deleted": {value: aws.String("value"), description: "desc", deleted: true},
+			"prod/empty":   {description: "desc"},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		client    *MockSecretsManagerClient
+		secret    secret
+		want      action
+		wantCalls []string
+		wantErr   bool
+	}{
+		{
+			"creates new",
+			&MockSecretsManagerClient{secrets: existing()},
+			secret{Name: "/prod/new", Value: "value"},
+			created,
+			[]string{"create prod/new"},
+			false,
+		},
+		{
+			"skips unchanged",
+			&MockSecretsManagerClient{secrets: existing()},
+			secret{Name: "/prod/same", Value: "value", Description: "desc", Pattern: "ignored"},
+			unchanged,
+			nil,
+			false,
+		},
+		{
+			"puts changed value",
+			&MockSecretsManagerClient{secrets: existing()},
+			secret{Name: "/prod/same", Value: "new value", Description: "desc"},
+			updated,
+			[]string{"put prod/same"},
+			false,
+		},
+		{
+			"updates changed description",
+			&MockSecretsManagerClient{secrets: existing()},
+			secret{Name: "/prod/same", Value: "value", Description: "new desc"},
+			updated,
+			[]string{"update prod/same"},
+			false,
+		},
+		{
+			"puts missing value",
+			&MockSecretsManagerClient{secrets: existing()},
+			secret{Name: "/prod/empty", Value: "value", Description: "desc"},
+			updated,
+			[]string{"put prod/empty"},
+			false,
+		},
+		{
+			"restores deleted",
+			&MockSecretsManagerClient{secrets: existing()},
+			secret{Name: "/prod/deleted", Value: "value", Description: "desc"},
+			updated,
+			[]string{"restore prod/deleted"},
+			false,
+		},
+		{
+			"propagates error",
+			&MockSecretsManagerClient{error: fmt.Errorf("my error")},
+			secret{Name: "/prod/new", Value: "value"},
+			"",
+			nil,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &secretsManagerCommitter{tt.client}
+			got, err := s.Sync(tt.secret)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("secretsManagerCommitter.Sync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Action != tt.want {
+				t.Errorf("secretsManagerCommitter.Sync() = %v, want %v", got.Action, tt.want)
+			}
+			if !reflect.DeepEqual(tt.client.calls, tt.wantCalls) {
+				t.Errorf("secretsManagerCommitter.Sync() called %v, want %v", tt.client.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func Test_secretsManagerCommitter_Delete(t *testing.T) {
+	tests := []struct {
+		name      string
+		client    *MockSecretsManagerClient
+		wantCalls []string
+		wantErr   bool
+	}{
+		{
+			"deletes",
+			&MockSecretsManagerClient{secrets: map[string]*mockSecret{"prod/gone": {}}},
+			[]string{"delete prod/gone"},
+			false,
+		},
+		{
+			"already gone is fine",
+			&MockSecretsManagerClient{},
+			nil,
+			false,
+		},
+		{
+			"propagates error",
+			&MockSecretsManagerClient{error: fmt.Errorf("my error")},
+			nil,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := (&secretsManagerCommitter{tt.client}).Delete("/prod/gone"); (err != nil) != tt.wantErr {
+				t.Errorf("secretsManagerCommitter.Delete() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(tt.client.calls, tt.wantCalls) {
+				t.Errorf("secretsManagerCommitter.Delete() called %v, want %v", tt.client.calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -1,18 +1,42 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"io"
+	"strings"
 )
 
+// the KMS key SecureString parameters are encrypted with when none is given
+const defaultKeyID = "alias/aws/ssm"
+
 // return a new syncer which commits values to the SSM api
 func newCommitter() syncer {
-	return &committer{ssm.New(session.Must(session.NewSession()))}
+	return &committer{newClient()}
+}
+
+// a parameter store client which retries and limits its calls as configured
+func newClient() ssmiface.SSMAPI {
+	// retries are done by retryingClient instead, to log them and back off as configured
+	var client ssmiface.SSMAPI = ssm.New(session.Must(session.NewSession(aws.NewConfig().WithMaxRetries(0))))
+	if *maxRPS > 0 {
+		// beneath the retries, so that they're limited too
+		client = limitedClient{client, newLimiter(*maxRPS)}
+	}
+	return retryingClient{client, newBackoff()}
+}
+
+// return a new syncer which compares values against the SSM api and writes what would change to the provided writer
+func newDiffer(writer io.Writer) syncer {
+	return &differ{newClient(), writer}
 }
 
 // return a new syncer which writes secret metadata (not the value itself) to the provided writer
@@ -20,6 +44,7 @@ func newPrinter(writer io.Writer) syncer {
 	encoder := json.NewEncoder(writer)
 	return &printer{
 		encoder,
+		writer,
 	}
 }
 
@@ -28,9 +53,48 @@ type committer struct {
 	ssmiface.SSMAPI
 }
 
-func (s *committer) Sync(secret secret) error {
-	if _, err := s.PutParameter(makeInput(secret)); err != nil {
-		return fmt.Errorf("failed uploading %v: %v", secret.Name, err)
+func (s *committer) Sync(secret secret) (result, error) {
+	current, version, err := fetch(s, secret.Name)
+	if err != nil {
+		return result{}, err
+	}
+
+	// every put creates a new version, and SSM only keeps so many; don't write what's already there
+	input := keepTier(current, makeInput(secret))
+	compared := keepExpiry(current, input, secret)
+	action := classify(current, compared)
+
+	if action != unchanged {
+		put := compared
+		if current == nil || aws.StringValue(current.Value) != secret.Value {
+			// a new value starts the clock on relative expirations again
+			put = input
+		}
+		out, err := s.PutParameter(put)
+		if err != nil {
+			return result{}, fmt.Errorf("failed uploading %v: %v", secret.Name, err)
+		}
+		version = aws.Int64Value(out.Version)
+	}
+
+	// overwriting puts can't set tags, so they're done separately
+	retagged, err := s.reconcileTags(secret)
+	if err != nil {
+		return result{}, err
+	}
+	if action == unchanged && retagged {
+		action = updated
+	}
+
+	return result{Action: action, Version: version}, nil
+}
+
+func (s *committer) Delete(name string) error {
+	if _, err := s.DeleteParameter(&ssm.DeleteParameterInput{Name: aws.String(name)}); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+			return nil // already gone
+		}
+		return fmt.Errorf("failed deleting %v: %v", name, err)
 	}
 
 	return nil
@@ -38,28 +102,259 @@ func (s *committer) Sync(secret secret) error {
 
 func makeInput(secret secret) *ssm.PutParameterInput {
 	tier := aws.String("Standard")
-	// automatically bump to Advanced param if >4K in size
-	if len(secret.Value) > 4096 {
+	// automatically bump to Advanced param if >4K in size, or with policies, which only it supports
+	if len(secret.Value) > 4096 || len(secret.Policies) > 0 {
 		tier = aws.String("Advanced")
 	}
+	var policies *string
+	if len(secret.Policies) > 0 {
+		policies = aws.String(policiesJSON(secret.Policies))
+	}
+	paramType := aws.String(ssm.ParameterTypeSecureString)
+	if !secret.secure() {
+		paramType = aws.String(secret.Type)
+	}
+	var keyID *string
+	if secret.KeyID != "" && secret.secure() {
+		keyID = aws.String(secret.KeyID)
+	}
 	return &ssm.PutParameterInput{
+		KeyId:          keyID,
+		Policies:       policies,
 		AllowedPattern: &secret.Pattern,
 		Description:    &secret.Description,
 		Value:          &secret.Value,
-		Overwrite:      aws.Bool(true),                            // always overwrite
-		Type:           aws.String(ssm.ParameterTypeSecureString), // always secure
+		Overwrite:      aws.Bool(true), // always overwrite
+		Type:           paramType,
 		Name:           &secret.Name,
 		Tier:           tier,
 	}
 }
 
+// parameters can't go back from Advanced to Standard, so the input for one that's Advanced stays so
+func keepTier(current, input *ssm.PutParameterInput) *ssm.PutParameterInput {
+	if current == nil || aws.StringValue(current.Tier) != ssm.ParameterTierAdvanced ||
+		aws.StringValue(input.Tier) == ssm.ParameterTierAdvanced {
+		return input
+	}
+	kept := *input
+	kept.Tier = current.Tier
+	return &kept
+}
+
 // a "syncer" which outputs secrets (excluding the actual secret value) as JSON
 // note that redaction behavior relies on `secret.Value` having a json name tag of '-' to
 // redact the value
 type printer struct {
 	*json.Encoder
+	out io.Writer
+}
+
+func (s *printer) Sync(secret secret) (result, error) {
+	if secret.KeyID == "" && secret.secure() {
+		secret.KeyID = defaultKeyID
+	}
+	return result{}, s.Encode(secret)
+}
+
+func (s *printer) output() io.Writer {
+	return s.out
+}
+
+func (s *printer) withOutput(w io.Writer) syncer {
+	return newPrinter(w)
+}
+
+func (s *printer) Delete(name string) error {
+	return s.Encode(struct {
+		Name   string `json:"name"`
+		Delete bool   `json:"delete"`
+	}{name, true})
+}
+
+// a "syncer" which compares each secret against what's already in the parameter store and writes
+// a summary of the differences; changed values are only ever written as a hash
+type differ struct {
+	ssmiface.SSMAPI
+	out io.Writer
+}
+
+func (s *differ) Sync(secret secret) (result, error) {
+	current, _, err := fetch(s, secret.Name)
+	if err != nil {
+		return result{}, err
+	}
+
+	input := keepExpiry(current, keepTier(current, makeInput(secret)), secret)
+
+	// as applying would reconcile them, after the put
+	var retagging []string
+	if current != nil {
+		tags, err := listTags(s, secret.Name)
+		if err != nil {
+			return result{}, err
+		}
+		retagging = tagChanges(tags, desiredTags(secret))
+	}
+
+	action := classify(current, input)
+	if action == unchanged && len(retagging) > 0 {
+		action = updated
+	}
+	_, err = fmt.Fprint(s.out, diff(current, input, retagging))
+	return result{Action: action}, err
+}
+
+func (s *differ) output() io.Writer {
+	return s.out
+}
+
+func (s *differ) withOutput(w io.Writer) syncer {
+	return &differ{s.SSMAPI, w}
+}
+
+func (s *differ) Delete(name string) error {
+	_, err := fmt.Fprintf(s.out, "- %v (deleted)\n", name)
+	return err
+}
+
+// fetches a parameter with its metadata from the SSM api, in the form of the input which would
+// produce it, and its version; nil if there is no such parameter
+func fetch(api ssmiface.SSMAPI, name string) (*ssm.PutParameterInput, int64, error) {
+	out, err := api.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("failed fetching %v: %v", name, err)
+	}
+
+	meta, err := api.DescribeParameters(&ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{{
+			Key:    aws.String("Name"),
+			Option: aws.String("Equals"),
+			Values: aws.StringSlice([]string{name}),
+		}},
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed describing %v: %v", name, err)
+	}
+
+	input := &ssm.PutParameterInput{
+		AllowedPattern: aws.String(""),
+		Description:    aws.String(""),
+		Value:          out.Parameter.Value,
+		Overwrite:      aws.Bool(true),
+		Type:           out.Parameter.Type,
+		Name:           aws.String(name),
+	}
+	if len(meta.Parameters) > 0 {
+		input.AllowedPattern = aws.String(aws.StringValue(meta.Parameters[0].AllowedPattern))
+		input.Description = aws.String(aws.StringValue(meta.Parameters[0].Description))
+		input.Tier = meta.Parameters[0].Tier
+		input.KeyId = meta.Parameters[0].KeyId
+
+		var texts []string
+		for _, p := range meta.Parameters[0].Policies {
+			texts = append(texts, aws.StringValue(p.PolicyText))
+		}
+		if len(texts) > 0 {
+			input.Policies = aws.String("[" + strings.Join(texts, ",") + "]")
+		}
+	}
+	return input, aws.Int64Value(out.Parameter.Version), nil
+}
+
+// describes how the current parameter (nil if absent) differs from the desired one, along with any
+// changes to its tags: new parameters are marked with '+', unchanged with '=' and changed with '~'
+// followed by one indented line per change (deleted ones get a '-')
+func diff(current, desired *ssm.PutParameterInput, tagChanges []string) string {
+	name := aws.StringValue(desired.Name)
+	if current == nil {
+		return fmt.Sprintf("+ %v (new)\n", name)
+	}
+
+	all := append(changes(current, desired), tagChanges...)
+	if len(all) == 0 {
+		return fmt.Sprintf("= %v (unchanged)\n", name)
+	}
+
+	out := fmt.Sprintf("~ %v\n", name)
+	for _, change := range all {
+		out += fmt.Sprintf("    %v\n", change)
+	}
+	return out
+}
+
+// what writing the desired parameter over the current one (nil if absent) amounts to
+func classify(current, desired *ssm.PutParameterInput) action {
+	if current == nil {
+		return created
+	}
+	if len(changes(current, desired)) == 0 {
+		return unchanged
+	}
+	return updated
+}
+
+// lists the differences between two parameter inputs, in a form fit for printing
+func changes(current, desired *ssm.PutParameterInput) []string {
+	var changes []string
+	if aws.StringValue(current.Value) != aws.StringValue(desired.Value) {
+		changes = append(changes, fmt.Sprintf("value: %v -> %v", hash(current.Value), hash(desired.Value)))
+	}
+	if aws.StringValue(current.Description) != aws.StringValue(desired.Description) {
+		changes = append(changes, fmt.Sprintf("description: %q -> %q",
+			aws.StringValue(current.Description), aws.StringValue(desired.Description)))
+	}
+	if aws.StringValue(current.AllowedPattern) != aws.StringValue(desired.AllowedPattern) {
+		changes = append(changes, fmt.Sprintf("pattern: %q -> %q",
+			aws.StringValue(current.AllowedPattern), aws.StringValue(desired.AllowedPattern)))
+	}
+	if keyID(current) != keyID(desired) {
+		changes = append(changes, fmt.Sprintf("key: %v -> %v", keyID(current), keyID(desired)))
+	}
+	if comparablePolicies(aws.StringValue(current.Policies)) != comparablePolicies(aws.StringValue(desired.Policies)) {
+		changes = append(changes, fmt.Sprintf("policies: %v -> %v",
+			aws.StringValue(current.Policies), aws.StringValue(desired.Policies)))
+	}
+	if aws.StringValue(current.Tier) != aws.StringValue(desired.Tier) {
+		changes = append(changes, fmt.Sprintf("tier: %v -> %v",
+			aws.StringValue(current.Tier), aws.StringValue(desired.Tier)))
+	}
+	if aws.StringValue(current.Type) != aws.StringValue(desired.Type) {
+		changes = append(changes, fmt.Sprintf("type: %v -> %v",
+			aws.StringValue(current.Type), aws.StringValue(desired.Type)))
+	}
+	return changes
+}
+
+// the KMS key a parameter is encrypted with, which is the default if none is given
+func keyID(input *ssm.PutParameterInput) string {
+	if aws.StringValue(input.KeyId) == "" {
+		return defaultKeyID
+	}
+	return aws.StringValue(input.KeyId)
+}
+
+// keys the fingerprints of values, anew every run, so that they can't be checked against guesses
+var hashKey = randomKey()
+
+func randomKey() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
 }
 
-func (s *printer) Sync(secret secret) error {
-	return s.Encode(secret)
+// a short, printable fingerprint of a value which doesn't reveal the value itself, and only means anything
+// compared to others from the same run
+func hash(value *string) string {
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write([]byte(aws.StringValue(value)))
+	return fmt.Sprintf("hmac:%x", mac.Sum(nil))[:17]
 }
@@ -1,24 +1,166 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"bytes"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 )
 
 type MockClient struct {
 	ssmiface.SSMAPI
-	error error
+	error    error
+	failures []error // returned by the first gets and puts, one each, before they succeed
+	params   map[string]*ssm.PutParameterInput
+	tags     map[string]map[string]string // by parameter name
+	puts     []string
+	lastPut  *ssm.PutParameterInput
+	deletes  []string
+	tagCalls []string
+}
+
+// the next failure, if any are left
+func (c *MockClient) fail() error {
+	if len(c.failures) == 0 {
+		return nil
+	}
+	err := c.failures[0]
+	c.failures = c.failures[1:]
+	return err
+}
+
+func (c *MockClient) DeleteParameter(input *ssm.DeleteParameterInput) (*ssm.DeleteParameterOutput, error) {
+	if c.error != nil {
+		return nil, c.error
+	}
+	if _, ok := c.params[*input.Name]; !ok {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "not found", nil)
+	}
+	c.deletes = append(c.deletes, *input.Name)
+	return &ssm.DeleteParameterOutput{}, nil
+}
+
+func (c *MockClient) ListTagsForResource(input *ssm.ListTagsForResourceInput) (*ssm.ListTagsForResourceOutput, error) {
+	if c.error != nil {
+		return nil, c.error
+	}
+	out := &ssm.ListTagsForResourceOutput{}
+	for key, value := range c.tags[*input.ResourceId] {
+		out.TagList = append(out.TagList, &ssm.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return out, nil
+}
+
+func (c *MockClient) AddTagsToResource(input *ssm.AddTagsToResourceInput) (*ssm.AddTagsToResourceOutput, error) {
+	for _, tag := range input.Tags {
+		c.tagCalls = append(c.tagCalls, fmt.Sprintf("add %v %v=%v", *input.ResourceId, *tag.Key, *tag.Value))
+	}
+	return &ssm.AddTagsToResourceOutput{}, nil
+}
+
+func (c *MockClient) RemoveTagsFromResource(input *ssm.RemoveTagsFromResourceInput) (*ssm.RemoveTagsFromResourceOutput, error) {
+	for _, key := range input.TagKeys {
+		c.tagCalls = append(c.tagCalls, fmt.Sprintf("remove %v %v", *input.ResourceId, *key))
+	}
+	return &ssm.RemoveTagsFromResourceOutput{}, nil
+}
+
+// one parameter per page, to exercise paging
+func (c *MockClient) GetParametersByPath(input *ssm.GetParametersByPathInput) (*ssm.GetParametersByPathOutput, error) {
+	if c.error != nil {
+		return nil, c.error
+	}
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range c.params {
+		if strings.HasPrefix(name, *input.Path+"/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	i, _ := strconv.Atoi(aws.StringValue(input.NextToken))
+	out := &ssm.GetParametersByPathOutput{}
+	if i < len(names) {
+		out.Parameters = []*ssm.Parameter{{Name: aws.String(names[i])}}
+	}
+	if i+1 < len(names) {
+		out.NextToken = aws.String(strconv.Itoa(i + 1))
+	}
+	return out, nil
+}
+
+func (c *MockClient) GetParametersByPathPages(input *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool) error {
+	return pagesByPath(c.GetParametersByPath, input, fn)
 }
 
 func (c *MockClient) PutParameter(input *ssm.PutParameterInput) (*ssm.PutParameterOutput, error) {
-	return nil, c.error
+	if c.error != nil {
+		return nil, c.error
+	}
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	c.puts = append(c.puts, *input.Name)
+	c.lastPut = input
+	return &ssm.PutParameterOutput{Version: aws.Int64(4)}, nil
+}
+
+func (c *MockClient) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	if c.error != nil {
+		return nil, c.error
+	}
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	param, ok := c.params[*input.Name]
+	if !ok {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "not found", nil)
+	}
+	return &ssm.GetParameterOutput{
+		Parameter: &ssm.Parameter{Name: param.Name, Value: param.Value, Type: param.Type, Version: aws.Int64(3)},
+	}, nil
+}
+
+func (c *MockClient) DescribeParameters(input *ssm.DescribeParametersInput) (*ssm.DescribeParametersOutput, error) {
+	out := &ssm.DescribeParametersOutput{}
+	for _, name := range input.ParameterFilters[0].Values {
+		if param, ok := c.params[*name]; ok {
+			var policies []*ssm.ParameterInlinePolicy
+			if param.Policies != nil {
+				var texts []json.RawMessage
+				if err := json.Unmarshal([]byte(*param.Policies), &texts); err != nil {
+					return nil, err
+				}
+				for _, text := range texts {
+					policies = append(policies, &ssm.ParameterInlinePolicy{PolicyText: aws.String(string(text))})
+				}
+			}
+			out.Parameters = append(out.Parameters, &ssm.ParameterMetadata{
+				Name:           param.Name,
+				AllowedPattern: param.AllowedPattern,
+				Description:    param.Description,
+				Tier:           param.Tier,
+				Type:           param.Type,
+				KeyId:          param.KeyId,
+				Policies:       policies,
+			})
+		}
+	}
+	return out, nil
 }
 
 func Test_committer_Handle(t *testing.T) {
@@ -26,28 +168,109 @@ func Test_committer_Handle(t *testing.T) {
 		secret secret
 	}
 
+	current := map[string]*ssm.PutParameterInput{
+		"/same": makeInput(secret{Name: "/same", Value: "value", Description: "desc"}),
+	}
+	tags := map[string]map[string]string{"/same": {managedByTag: managedByValue}}
+
 	tests := []struct {
-		name    string
-		s       *committer
-		args    args
-		wantErr bool
+		name        string
+		client      *MockClient
+		args        args
+		want        action
+		wantPuts    []string
+		wantVersion int64
+		wantErr     bool
 	}{
 		{
 			name:    "propagates error",
-			s:       &committer{&MockClient{error: fmt.Errorf("my error")}},
+			client:  &MockClient{error: fmt.Errorf("my error")},
 			wantErr: true,
 		},
 		{
-			name: "no error is successful",
-			s:    &committer{&MockClient{}},
+			name:        "no error is successful",
+			client:      &MockClient{},
+			args:        args{secret{Name: "/new"}},
+			want:        created,
+			wantPuts:    []string{"/new"},
+			wantVersion: 4,
+		},
+		{
+			name:        "skips unchanged",
+			client:      &MockClient{params: current, tags: tags},
+			args:        args{secret{Name: "/same", Value: "value", Description: "desc"}},
+			want:        unchanged,
+			wantVersion: 3,
+		},
+		{
+			name:        "writes changed value",
+			client:      &MockClient{params: current},
+			args:        args{secret{Name: "/same", Value: "new value", Description: "desc"}},
+			want:        updated,
+			wantPuts:    []string{"/same"},
+			wantVersion: 4,
+		},
+		{
+			name:        "writes changed metadata",
+			client:      &MockClient{params: current},
+			args:        args{secret{Name: "/same", Value: "value", Description: "desc", Pattern: "^.*$"}},
+			want:        updated,
+			wantPuts:    []string{"/same"},
+			wantVersion: 4,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := tt.s.Sync(tt.args.secret); (err != nil) != tt.wantErr {
+			s := &committer{tt.client}
+			got, err := s.Sync(tt.args.secret)
+			if (err != nil) != tt.wantErr {
 				t.Errorf("committer.Sync() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if got.Action != tt.want {
+				t.Errorf("committer.Sync() = %v, want %v", got.Action, tt.want)
+			}
+			if !reflect.DeepEqual(tt.client.puts, tt.wantPuts) {
+				t.Errorf("committer.Sync() put %v, want %v", tt.client.puts, tt.wantPuts)
+			}
+			if got.Version != tt.wantVersion {
+				t.Errorf("committer.Sync() version = %v, want %v", got.Version, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func Test_committer_Delete(t *testing.T) {
+	tests := []struct {
+		name        string
+		client      *MockClient
+		wantDeletes []string
+		wantErr     bool
+	}{
+		{
+			name:        "deletes",
+			client:      &MockClient{params: map[string]*ssm.PutParameterInput{"/gone": {}}},
+			wantDeletes: []string{"/gone"},
+		},
+		{
+			name:   "already gone is fine",
+			client: &MockClient{},
+		},
+		{
+			name:    "propagates error",
+			client:  &MockClient{error: fmt.Errorf("my error")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := (&committer{tt.client}).Delete("/gone"); (err != nil) != tt.wantErr {
+				t.Errorf("committer.Delete() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(tt.client.deletes, tt.wantDeletes) {
+				t.Errorf("committer.Delete() deleted %v, want %v", tt.client.deletes, tt.wantDeletes)
+			}
 		})
 	}
 }
@@ -62,10 +285,10 @@ func Test_makeInput(t *testing.T) {
 		{
 			"all fields",
 			secret{
-				"/blah/blah/hi",
-				"secret value",
-				"I am a description",
-				"^.*$",
+				Name:        "/blah/blah/hi",
+				Value:       "secret value",
+				Description: "I am a description",
+				Pattern:     "^.*$",
 			},
 			&ssm.PutParameterInput{
 				AllowedPattern: aws.String("^.*$"),
@@ -77,13 +300,58 @@ func Test_makeInput(t *testing.T) {
 				Tier:           aws.String("Standard"),
 			},
 		},
+		{
+			"custom key",
+			secret{Name: "/blah/blah/hi", Value: "secret value", KeyID: "alias/prod"},
+			&ssm.PutParameterInput{
+				AllowedPattern: aws.String(""),
+				Description:    aws.String(""),
+				KeyId:          aws.String("alias/prod"),
+				Name:           aws.String("/blah/blah/hi"),
+				Overwrite:      aws.Bool(true),
+				Type:           aws.String(ssm.ParameterTypeSecureString),
+				Value:          aws.String("secret value"),
+				Tier:           aws.String("Standard"),
+			},
+		},
+		{
+			"string has no key",
+			secret{Name: "/blah/blah/hi", Value: "a,b", Type: ssm.ParameterTypeStringList, KeyID: "alias/prod"},
+			&ssm.PutParameterInput{
+				AllowedPattern: aws.String(""),
+				Description:    aws.String(""),
+				Name:           aws.String("/blah/blah/hi"),
+				Overwrite:      aws.Bool(true),
+				Type:           aws.String(ssm.ParameterTypeStringList),
+				Value:          aws.String("a,b"),
+				Tier:           aws.String("Standard"),
+			},
+		},
+		{
+			"policies are advanced",
+			secret{
+				Name:     "/blah/blah/hi",
+				Value:    "secret value",
+				Policies: []policy{{"NoChangeNotification", "1.0", map[string]string{"After": "30", "Unit": "Days"}, false}},
+			},
+			&ssm.PutParameterInput{
+				AllowedPattern: aws.String(""),
+				Description:    aws.String(""),
+				Name:           aws.String("/blah/blah/hi"),
+				Overwrite:      aws.Bool(true),
+				Type:           aws.String(ssm.ParameterTypeSecureString),
+				Value:          aws.String("secret value"),
+				Tier:           aws.String("Advanced"),
+				Policies:       aws.String(`[{"Type":"NoChangeNotification","Version":"1.0","Attributes":{"After":"30","Unit":"Days"}}]`),
+			},
+		},
 		{
 			"too big for standard parameter",
 			secret{
-				"/blah/blah/hi",
-				fiveThousandBytes,
-				"I am a description",
-				"^.*$",
+				Name:        "/blah/blah/hi",
+				Value:       fiveThousandBytes,
+				Description: "I am a description",
+				Pattern:     "^.*$",
 			},
 			&ssm.PutParameterInput{
 				AllowedPattern: aws.String("^.*$"),
@@ -107,7 +375,7 @@ func Test_makeInput(t *testing.T) {
 }
 
 func Test_printer_Handle(t *testing.T) {
-	expected := "{\"name\":\"hi\"}\n"
+	expected := "{\"name\":\"hi\",\"keyId\":\"alias/aws/ssm\"}\n"
 	buf := new(bytes.Buffer)
 	newPrinter(buf).Sync(secret{
 		Name:  "hi",
@@ -118,3 +386,199 @@ func Test_printer_Handle(t *testing.T) {
 		t.Errorf("Expected %v; got %v", expected, buf.String())
 	}
 }
+
+// an expiration as expire-after makes them
+func relativeExpiration(at time.Time) policy {
+	p := expiration(at)
+	p.relative = true
+	return p
+}
+
+func Test_differ_Sync(t *testing.T) {
+	advanced := makeInput(secret{Name: "/advanced", Value: "value"})
+	advanced.Tier = aws.String(ssm.ParameterTierAdvanced)
+	current := map[string]*ssm.PutParameterInput{
+		"/same":     makeInput(secret{Name: "/same", Value: "value", Description: "desc"}),
+		"/advanced": advanced,
+		"/changed": makeInput(secret{
+			Name:        "/changed",
+			Value:       "old value",
+			Description: "old desc",
+		}),
+		"/expiring": makeInput(secret{
+			Name:     "/expiring",
+			Value:    "value",
+			Policies: []policy{expiration(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))},
+		}),
+	}
+	tags := make(map[string]map[string]string)
+	for name := range current {
+		tags[name] = map[string]string{managedByTag: managedByValue}
+	}
+	tags["/tagged"] = map[string]string{managedByTag: managedByValue, "owner": "platform", "team": "payments"}
+	current["/tagged"] = makeInput(secret{Name: "/tagged", Value: "value"})
+
+	tests := []struct {
+		name       string
+		client     *MockClient
+		secret     secret
+		want       string
+		wantAction action
+		wantErr    bool
+	}{
+		{
+			"new",
+			&MockClient{params: current, tags: tags},
+			secret{Name: "/new", Value: "value"},
+			"+ /new (new)\n",
+			created,
+			false,
+		},
+		{
+			"unchanged",
+			&MockClient{params: current, tags: tags},
+			secret{Name: "/same", Value: "value", Description: "desc"},
+			"= /same (unchanged)\n",
+			unchanged,
+			false,
+		},
+		{
+			"default key is unchanged",
+			&MockClient{params: current, tags: tags},
+			secret{Name: "/same", Value: "value", Description: "desc", KeyID: "alias/aws/ssm"},
+			"= /same (unchanged)\n",
+			unchanged,
+			false,
+		},
+		{
+			"changed key",
+			&MockClient{params: current, tags: tags},
+			secret{Name: "/same", Value: "value", Description: "desc", KeyID: "alias/prod"},
+			"~ /same\n" +
+				"    key: alias/aws/ssm -> alias/prod\n",
+			updated,
+			false,
+		},
+		{
+			"advanced stays advanced",
+			&MockClient{params: current, tags: tags},
+			secret{Name: "/advanced", Value: "value"},
+			"= /advanced (unchanged)\n",
+			unchanged,
+			false,
+		},
+		{
+			"changed tags",
+			&MockClient{params: current, tags: tags},
+			secret{Name: "/tagged", Value: "value", Tags: map[string]string{"owner": "security", "env": "prod"}},
+			"~ /tagged\n" +
+				"    tag env: (none) -> \"prod\"\n" +
+				"    tag owner: \"platform\" -> \"security\"\n" +
+				"    tag team: \"payments\" -> (none)\n",
+			updated,
+			false,
+		},
+		{
+			"recomputed relative expiration is unchanged",
+			&MockClient{params: current, tags: tags},
+			secret{
+				Name:     "/expiring",
+				Value:    "value",
+				Policies: []policy{relativeExpiration(time.Date(2030, 2, 1, 0, 0, 0, 0, time.UTC))},
+			},
+			"= /expiring (unchanged)\n",
+			unchanged,
+			false,
+		},
+		{
+			"changed absolute expiration",
+			&MockClient{params: current, tags: tags},
+			secret{
+				Name:     "/expiring",
+				Value:    "value",
+				Policies: []policy{expiration(time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC))},
+			},
+			"~ /expiring\n" +
+				"    policies: [{\"Type\":\"Expiration\",\"Version\":\"1.0\",\"Attributes\":{\"Timestamp\":\"2030-01-01T00:00:00Z\"}}] -> " +
+				"[{\"Type\":\"Expiration\",\"Version\":\"1.0\",\"Attributes\":{\"Timestamp\":\"2031-01-01T00:00:00Z\"}}]\n",
+			updated,
+			false,
+		},
+		{
+			"changed policies",
+			&MockClient{params: current, tags: tags},
+			secret{
+				Name:  "/expiring",
+				Value: "value",
+				Policies: []policy{
+					expiration(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)),
+					{"ExpirationNotification", "1.0", map[string]string{"Before": "14", "Unit": "Days"}, false},
+				},
+			},
+			"~ /expiring\n" +
+				"    policies: [{\"Type\":\"Expiration\",\"Version\":\"1.0\",\"Attributes\":{\"Timestamp\":\"2030-01-01T00:00:00Z\"}}] -> " +
+				"[{\"Type\":\"Expiration\",\"Version\":\"1.0\",\"Attributes\":{\"Timestamp\":\"2030-01-01T00:00:00Z\"}}," +
+				"{\"Type\":\"ExpirationNotification\",\"Version\":\"1.0\",\"Attributes\":{\"Before\":\"14\",\"Unit\":\"Days\"}}]\n",
+			updated,
+			false,
+		},
+		{
+			"changed",
+			&MockClient{params: current, tags: tags},
+			secret{Name: "/changed", Value: "new value", Description: "new desc", Pattern: "^.*$"},
+			"~ /changed\n" +
+				"    value: " + hash(aws.String("old value")) + " -> " + hash(aws.String("new value")) + "\n" +
+				"    description: \"old desc\" -> \"new desc\"\n" +
+				"    pattern: \"\" -> \"^.*$\"\n",
+			updated,
+			false,
+		},
+		{
+			"propagates error",
+			&MockClient{error: fmt.Errorf("my error")},
+			secret{Name: "/new", Value: "value"},
+			"",
+			"",
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			d := &differ{tt.client, buf}
+			got, err := d.Sync(tt.secret)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("differ.Sync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Action != tt.wantAction {
+				t.Errorf("differ.Sync() = %v, want %v", got.Action, tt.wantAction)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("differ.Sync() wrote %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_hash(t *testing.T) {
+	a, b := hash(aws.String("value")), hash(aws.String("other value"))
+	if a != hash(aws.String("value")) || a == b {
+		t.Errorf("hash() = %v and %v", a, b)
+	}
+
+	plain := fmt.Sprintf("%x", sha256.Sum256([]byte("value")))
+	if strings.Contains(plain, strings.TrimPrefix(a, "hmac:")) {
+		t.Errorf("hash() = %v, a plain SHA-256", a)
+	}
+}
+
+func Test_printer_Delete(t *testing.T) {
+	expected := "{\"name\":\"hi\",\"delete\":true}\n"
+	buf := new(bytes.Buffer)
+	newPrinter(buf).Delete("hi")
+
+	if expected != buf.String() {
+		t.Errorf("Expected %v; got %v", expected, buf.String())
+	}
+}
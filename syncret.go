@@ -2,50 +2,155 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
 )
 
-const doc = `Usage of %s [FILE ...]:
+var (
+	commit    = flag.Bool("commit", false, "Sync changes to the parameter store rather than just printing metadata")
+	diffOnly  = flag.Bool("diff", false, "Print how secrets differ from the parameter store rather than just printing metadata")
+	target    = flag.String("target", "ssm", "Where apply (or -commit) syncs secrets to: ssm, secretsmanager, vault or kubernetes")
+	keepGoing = flag.Bool("keep-going", false, "Sync every secret possible despite failures, then summarize them")
+	parallel  = flag.Int("parallel", 1, "How many secrets to decrypt and sync at once")
+	gitRange  = flag.String("git-range", "", "Sync the secrets changed in a git revision range like A..B, including deletions")
+	prune     stringList
+	tagFlags  stringList
 
-Synchronizes a directory of encrypted secrets and metadata with AWS's parameter store.
+	// a line of `git diff --name-status` output, e.g. "M\tpath" or "R100\told\tnew"
+	nameStatus = regexp.MustCompile(`^([ACDMRTUX])[0-9]*\t(.+)$`)
+)
 
-By default, just prints metadata to stdout; provide the -commit flag to upload.
-				
-If files are provided as arguments, they will be used; otherwise, paths will be read from stdin.
+// the core struct; json serializable but drops value when so serialized.
+type secret struct {
+	Name        string            `json:"name"`
+	Value       string            `json:"-"`
+	Description string            `json:"description,omitempty"`
+	Pattern     string            `json:"pattern,omitempty"`
+	KeyID       string            `json:"keyId,omitempty"` // the KMS key to encrypt with, if not the default
+	Type        string            `json:"type,omitempty"`  // the parameter type, if not SecureString
+	Tags        map[string]string `json:"tags,omitempty"`
+	Policies    []policy          `json:"policies,omitempty"`
+}
 
-`
+// whether a secret is actually stored encrypted
+func (s secret) secure() bool {
+	return s.Type == "" || s.Type == ssm.ParameterTypeSecureString
+}
 
-var commit = flag.Bool("commit", false, "Sync changes to the parameter store rather than just printing metadata")
+// what a syncer did with a secret
+type action string
 
-// the core struct; json serializable but drops value when so serialized.
-type secret struct {
-	Name        string `json:"name"`
-	Value       string `json:"-"`
-	Description string `json:"description,omitempty"`
-	Pattern     string `json:"pattern,omitempty"`
+const (
+	created   action = "create"
+	updated   action = "update"
+	unchanged action = "unchanged"
+	deleted   action = "delete"
+	failed    action = "failed"
+	// for syncers which don't know what they did, e.g. the printer
+	synced action = "sync"
+)
+
+// the outcome of syncing a single secret; syncers which don't know what they did leave it empty, and
+// only those with versions set the version written
+type result struct {
+	Action  action
+	Version int64
 }
 
-// "syncs" a secret, which either succeeds or fails with an error
+// "syncs" a secret, which either succeeds or fails with an error; likewise for deleting one by name
 type syncer interface {
-	Sync(secret secret) error
+	Sync(secret secret) (result, error)
+	Delete(name string) error
+}
+
+// implemented by syncers which hold on to what's synced, to write it all at the end of a run
+type flusher interface {
+	Flush() error
+}
+
+// implemented by syncers which write output as they sync; so that it stays in order when syncing in
+// parallel, each secret's output is buffered by a copy of the syncer which writes to the buffer instead
+type outputter interface {
+	output() io.Writer
+	withOutput(w io.Writer) syncer
+}
+
+// given the secrets loaded, return the names of secrets which should be deleted
+type pruner interface {
+	Prune(secrets []secret) ([]string, error)
+}
+
+// everything about a run beyond what's loaded and where it's synced to
+type options struct {
+	pruners   []pruner
+	parallel  int
+	keepGoing bool
+	reporter  reporter
+}
+
+// the errors of a run which kept going, by the name of the secret (or the path, if it couldn't be
+// loaded) which failed
+type failures map[string]error
+
+func (f failures) Error() string {
+	var msgs []string
+	for _, name := range f.names() {
+		msgs = append(msgs, fmt.Sprintf("%v: %v", name, f[name]))
+	}
+	return fmt.Sprintf("%d failed: %v", len(f), strings.Join(msgs, "; "))
+}
+
+// the names which failed, sorted
+func (f failures) names() []string {
+	var names []string
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
 // given a list of paths, return the secrets found within or an error
 // basically pulled out for testing
 type loader interface {
 	LoadAll(paths []string) ([]secret, error)
+	// given a list of deleted paths, return the names of secrets deleted and the paths of secrets
+	// which only changed, and so should be loaded again
+	Deleted(paths []string) ([]string, []string, error)
+}
+
+// decrypts the secret file at a path; the loader picks one per file by its suffix
+type decryptor interface {
+	Decrypt(path string) ([]byte, error)
 }
 
 func init() {
-	// overwrite default usage text
-	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), doc, os.Args[0])
-		flag.PrintDefaults()
-	}
+	flag.Var(&prune, "prune", "Delete parameters under this path which don't exist locally (repeatable)")
+	flag.Var(&tagFlags, "tag", "A key=value tag for every parameter, unless its .tags file says otherwise (repeatable)")
 }
 
 // get a list of paths, either from stdin or from CLI arguments
@@ -62,41 +167,289 @@ func getPaths(in io.Reader, args []string) []string {
 	return paths
 }
 
-// given the paths, a loader, and a syncer, load the secret in each path and sync it
-func run(loader loader, syncer syncer, paths []string) error {
+// run `git diff --name-status` for the given range, from the given directory
+func gitChanges(dir, revRange string, pathspecs []string) ([]string, error) {
+	args := append([]string{"diff", "--name-status", "--relative", "-M", revRange, "--"}, pathspecs...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed diffing %v: %v", revRange, err)
+	}
+
+	var lines []string
+	for scanner := bufio.NewScanner(&out); scanner.Scan(); {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, nil
+}
+
+// splits lines into paths to sync and paths deleted; lines are either plain paths or
+// `git diff --name-status` output, where renames are a deletion plus an addition
+func parseChanges(lines []string) ([]string, []string) {
+	var paths, deleted []string
+	for _, line := range lines {
+		match := nameStatus.FindStringSubmatch(line)
+		if match == nil {
+			paths = append(paths, line)
+			continue
+		}
+
+		files := strings.Split(match[2], "\t")
+		switch {
+		case match[1] == "D":
+			deleted = append(deleted, files[0])
+		case match[1] == "R" && len(files) == 2:
+			deleted = append(deleted, files[0])
+			paths = append(paths, files[1])
+		default:
+			// copies come with their source, which is unchanged
+			paths = append(paths, files[len(files)-1])
+		}
+	}
+	return paths, deleted
+}
+
+// whether any of the lines are `git diff --name-status` output, rather than plain paths
+func isDiff(lines []string) bool {
+	for _, line := range lines {
+		if nameStatus.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// given the paths, a loader, and a syncer, load the secret in each path and sync it, then delete
+// whatever the pruners find; the reporter, if any, is given every outcome at the end
+func run(loader loader, syncer syncer, paths []string, opts options) (err error) {
+	var outcomes []outcome
+	if opts.reporter != nil {
+		defer func() {
+			if rerr := opts.reporter.Report(outcomes); rerr != nil && err == nil {
+				err = rerr
+			}
+		}()
+	}
+
 	secrets, err := loader.LoadAll(paths)
-	if err != nil {
+	errs, ok := err.(failures)
+	if err != nil && !(ok && opts.keepGoing) {
 		return err
 	}
+	if errs == nil {
+		errs = make(failures)
+	}
+
+	// check every value against its pattern before writing anything, so a bad one fails the whole sync
+	secrets, invalid := checkPatterns(secrets)
+	for name, err := range invalid {
+		errs[name] = err
+	}
+	for _, name := range errs.names() {
+		outcomes = append(outcomes, outcome{name: name, result: result{Action: failed}, err: errs[name]})
+	}
+	if len(invalid) > 0 && !opts.keepGoing {
+		return invalid
+	}
+
+	// work out deletions before writing anything, so failing to do so doesn't leave a partial sync; but
+	// what failed can't be told apart from what was deleted, so if anything did, prune nothing at all
+	var deletions []string
+	if len(errs) == 0 {
+		if deletions, err = prunable(secrets, opts.pruners); err != nil {
+			return err
+		}
+	} else if len(opts.pruners) > 0 {
+		log.Printf("Not pruning, since %d secrets failed", len(errs))
+	}
 
-	if len(secrets) == 0 {
+	if len(secrets) == 0 && len(deletions) == 0 && len(errs) == 0 {
 		return nil // no op
 	}
 
-	for _, secret := range secrets {
-		if err := syncer.Sync(secret); err != nil {
+	// each secret's output, if any, to be copied out in order
+	buffers := make([]bytes.Buffer, len(secrets))
+	results := make([]outcome, len(secrets))
+	out, buffered := syncer.(outputter)
+
+	reported := make([]bool, len(secrets))
+	report := func(i int, err error) {
+		reported[i] = true
+		if buffered {
+			buffers[i].WriteTo(out.output())
+		}
+		outcomes = append(outcomes, results[i])
+		switch results[i].Action {
+		case failed:
+			errs[secrets[i].Name] = err
+			log.Printf("Failed to sync: %s: %v", secrets[i].Name, err)
+		case unchanged:
+			log.Printf("Skipped unchanged: %s", secrets[i].Name)
+		default:
+			log.Printf("Successfully synced: %s", secrets[i].Name)
+		}
+	}
+
+	err = forEach(len(secrets), opts.parallel, opts.keepGoing, func(i int) error {
+		s := syncer
+		if buffered {
+			s = out.withOutput(&buffers[i])
+		}
+
+		start := time.Now()
+		r, err := s.Sync(secrets[i])
+		results[i] = outcome{secrets[i].Name, r, time.Since(start), redact(err, secrets[i].Value)}
+		if results[i].Action == "" {
+			results[i].Action = synced
+		}
+		if err != nil {
+			results[i].Action = failed
+		}
+		return results[i].err
+	}, report)
+	if err != nil && !opts.keepGoing {
+		// the failure stopped anything more from starting, but what had already started finished
+		for i := range results {
+			if !reported[i] && results[i].Action != "" {
+				report(i, results[i].err)
+			}
+		}
+		return err
+	}
+
+	for _, name := range deletions {
+		start := time.Now()
+		err := syncer.Delete(name)
+		o := outcome{name, result{Action: deleted}, time.Since(start), err}
+		if err != nil {
+			o.Action = failed
+		}
+		outcomes = append(outcomes, o)
+
+		if err != nil {
+			if !opts.keepGoing {
+				return err
+			}
+			errs[name] = err
+			log.Printf("Failed to delete: %s: %v", name, err)
+			continue
+		}
+		log.Printf("Successfully deleted: %s", name)
+	}
+
+	if f, ok := syncer.(flusher); ok {
+		if err := f.Flush(); err != nil {
 			return err
 		}
-		log.Printf("Successfully synced: %s", secret.Name)
 	}
 
+	counts := make(map[action]int)
+	for _, o := range outcomes {
+		counts[o.Action]++
+	}
+	log.Printf("Synced %d secrets, skipped %d unchanged, deleted %d",
+		counts[created]+counts[updated]+counts[synced], counts[unchanged], counts[deleted])
+
+	if opts.keepGoing {
+		summarize(log.Writer(), outcomes)
+		if len(errs) > 0 {
+			return errs
+		}
+	}
 	return nil
 }
 
-func main() {
-	flag.Parse()
+// what became of a secret, for the summary and any report
+type outcome struct {
+	name string
+	result
+	duration time.Duration
+	err      error
+}
 
-	var handler syncer
-	if *commit {
-		handler = newCommitter()
-	} else {
-		handler = newPrinter(os.Stdout)
+// writes a table of outcomes
+func summarize(w io.Writer, outcomes []outcome) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tACTION\tERROR")
+	for _, o := range outcomes {
+		var msg string
+		if o.err != nil {
+			msg = o.err.Error()
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", o.name, o.Action, msg)
 	}
+	tw.Flush()
+}
 
-	paths := getPaths(os.Stdin, flag.Args())
-	log.Printf("Found %d paths", len(paths))
+// the unique names found by all the pruners, in the order found
+func prunable(secrets []secret, pruners []pruner) ([]string, error) {
+	var deletions []string
+	seen := make(map[string]bool)
+	for _, p := range pruners {
+		names, err := p.Prune(secrets)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				deletions = append(deletions, name)
+			}
+		}
+	}
+	return deletions, nil
+}
 
-	if err := run(newLoader(), handler, paths); err != nil {
+// the syncer which commits to the named target
+func newTarget(name string) (syncer, error) {
+	switch name {
+	case "ssm":
+		return newCommitter(), nil
+	case "secretsmanager":
+		return newSecretsManagerCommitter(), nil
+	case "vault":
+		return newVaultCommitter()
+	case "kubernetes":
+		return newKubernetesSyncer()
+	default:
+		return nil, fmt.Errorf("unknown target %v", name)
+	}
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := lookupCommand(os.Args[1]); ok {
+			fs := cmd.flagSet()
+			fs.Parse(os.Args[2:])
+			if err := cmd.run(fs.Args()); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		if os.Args[1] == "help" {
+			if len(os.Args) > 2 {
+				if cmd, ok := lookupCommand(os.Args[2]); ok {
+					cmd.flagSet().Usage()
+					return
+				}
+			}
+			flag.Usage()
+			return
+		}
+	}
+
+	flag.Parse()
+	cmd, err := legacyCommand()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cmd.run(flag.Args()); err != nil {
 		log.Fatal(err)
 	}
 }
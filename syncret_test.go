@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -17,19 +20,44 @@ func (m *mockLoader) LoadAll(paths []string) ([]secret, error) {
 	return m.secrets, m.e
 }
 
+func (m *mockLoader) Deleted(paths []string) ([]string, []string, error) {
+	return nil, nil, m.e
+}
+
 type mockSyncer struct {
-	errors map[string]error
-	synced []string
+	mu      sync.Mutex
+	errors  map[string]error
+	synced  []string
+	deleted []string
 }
 
-func (m *mockSyncer) Sync(s secret) error {
+func (m *mockSyncer) Sync(s secret) (result, error) {
 	if e := m.errors[s.Name]; e != nil {
-		return e
+		return result{}, e
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.synced = append(m.synced, s.Name)
+	return result{Action: updated}, nil
+}
+
+func (m *mockSyncer) Delete(name string) error {
+	if e := m.errors[name]; e != nil {
+		return e
+	}
+	m.deleted = append(m.deleted, name)
 	return nil
 }
 
+type mockPruner struct {
+	names []string
+	e     error
+}
+
+func (m *mockPruner) Prune(secrets []secret) ([]string, error) {
+	return m.names, m.e
+}
+
 func Test_run(t *testing.T) {
 	type args struct {
 		loader loader
@@ -88,6 +116,23 @@ func Test_run(t *testing.T) {
 			make([]string, 0),
 			true,
 		},
+		{
+			"checks patterns before syncing anything",
+			args{
+				loader: &mockLoader{
+					secrets: []secret{
+						{Name: "/a", Value: "42", Pattern: `^\d+$`},
+						{Name: "/b", Value: "forty-two", Pattern: `^\d+$`},
+					},
+				},
+				syncer: &mockSyncer{
+					errors: make(map[string]error),
+					synced: make([]string, 0),
+				},
+			},
+			make([]string, 0),
+			true,
+		},
 		{
 			"partial sync",
 			args{
@@ -135,7 +180,7 @@ func Test_run(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := run(tt.args.loader, tt.args.syncer, []string{}); (err != nil) != tt.wantErr {
+			if err := run(tt.args.loader, tt.args.syncer, []string{}, options{}); (err != nil) != tt.wantErr {
 				t.Errorf("run() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if !reflect.DeepEqual(tt.want, tt.args.syncer.synced) {
@@ -145,6 +190,302 @@ func Test_run(t *testing.T) {
 	}
 }
 
+func Test_run_prunes(t *testing.T) {
+	tests := []struct {
+		name        string
+		loader      loader
+		pruners     []pruner
+		errors      map[string]error
+		wantSynced  []string
+		wantDeleted []string
+		wantErr     bool
+	}{
+		{
+			"deletes after syncing",
+			&mockLoader{secrets: []secret{{Name: "/kept"}}},
+			[]pruner{&mockPruner{names: []string{"/gone"}}},
+			nil,
+			[]string{"/kept"},
+			[]string{"/gone"},
+			false,
+		},
+		{
+			"deletes without secrets",
+			&mockLoader{},
+			[]pruner{&mockPruner{names: []string{"/gone"}}},
+			nil,
+			nil,
+			[]string{"/gone"},
+			false,
+		},
+		{
+			"dedupes across pruners",
+			&mockLoader{},
+			[]pruner{&mockPruner{names: []string{"/a", "/b"}}, &mockPruner{names: []string{"/b", "/c"}}},
+			nil,
+			nil,
+			[]string{"/a", "/b", "/c"},
+			false,
+		},
+		{
+			"pruner error syncs nothing",
+			&mockLoader{secrets: []secret{{Name: "/kept"}}},
+			[]pruner{&mockPruner{e: fmt.Errorf("no listing")}},
+			nil,
+			nil,
+			nil,
+			true,
+		},
+		{
+			"propagates delete error",
+			&mockLoader{},
+			[]pruner{&mockPruner{names: []string{"/a", "/b"}}},
+			map[string]error{"/a": fmt.Errorf("can't delete")},
+			nil,
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &mockSyncer{errors: tt.errors}
+			if err := run(tt.loader, s, []string{}, options{pruners: tt.pruners}); (err != nil) != tt.wantErr {
+				t.Errorf("run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(tt.wantSynced, s.synced) {
+				t.Errorf("synced = %v, want %v", s.synced, tt.wantSynced)
+			}
+			if !reflect.DeepEqual(tt.wantDeleted, s.deleted) {
+				t.Errorf("deleted = %v, want %v", s.deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func Test_run_parallel(t *testing.T) {
+	var secrets []secret
+	for i := 0; i < 20; i++ {
+		secrets = append(secrets, secret{Name: fmt.Sprintf("/secret/%02d", i)})
+	}
+
+	t.Run("syncs everything", func(t *testing.T) {
+		s := &mockSyncer{}
+		if err := run(&mockLoader{secrets: secrets}, s, []string{}, options{parallel: 4}); err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+		sort.Strings(s.synced)
+		if len(s.synced) != len(secrets) || s.synced[0] != "/secret/00" {
+			t.Errorf("synced = %v, want all of %v", s.synced, secrets)
+		}
+	})
+
+	t.Run("keeps output in order", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		if err := run(&mockLoader{secrets: secrets}, newPrinter(buf), []string{}, options{parallel: 4}); err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		for i, line := range lines {
+			if want := fmt.Sprintf(`{"name":"/secret/%02d","keyId":"alias/aws/ssm"}`, i); line != want {
+				t.Errorf("output line %v = %v, want %v", i, line, want)
+			}
+		}
+	})
+
+	t.Run("stops at failure", func(t *testing.T) {
+		s := &mockSyncer{errors: map[string]error{"/secret/02": fmt.Errorf("no")}}
+		if err := run(&mockLoader{secrets: secrets}, s, []string{}, options{parallel: 2}); err == nil {
+			t.Errorf("run() error = %v, wantErr %v", err, true)
+		}
+		if len(s.synced) >= len(secrets)-1 {
+			t.Errorf("synced = %v, want the rest cancelled", s.synced)
+		}
+	})
+
+	t.Run("reports what finished after a failure", func(t *testing.T) {
+		s := &mockSyncer{errors: map[string]error{"/secret/00": fmt.Errorf("no")}}
+		r := &mockReporter{}
+		if err := run(&mockLoader{secrets: secrets}, s, []string{}, options{parallel: 4, reporter: r}); err == nil {
+			t.Errorf("run() error = %v, wantErr %v", err, true)
+		}
+		var reported []string
+		for _, o := range r.outcomes {
+			if o.Action != failed {
+				reported = append(reported, o.name)
+			}
+		}
+		sort.Strings(s.synced)
+		if !reflect.DeepEqual(reported, s.synced) {
+			t.Errorf("reported %v, want everything synced: %v", reported, s.synced)
+		}
+	})
+}
+
+func Test_run_keepGoing(t *testing.T) {
+	tests := []struct {
+		name        string
+		loader      loader
+		pruners     []pruner
+		errors      map[string]error
+		wantSynced  []string
+		wantDeleted []string
+		wantFailed  []string
+	}{
+		{
+			"syncs past failures",
+			&mockLoader{secrets: []secret{{Name: "/a"}, {Name: "/b"}, {Name: "/c"}}},
+			nil,
+			map[string]error{"/b": fmt.Errorf("bad pattern")},
+			[]string{"/a", "/c"},
+			nil,
+			[]string{"/b"},
+		},
+		{
+			"syncs past load failures",
+			&mockLoader{secrets: []secret{{Name: "/a"}}, e: failures{"b.gpg": fmt.Errorf("can't decrypt")}},
+			nil,
+			nil,
+			[]string{"/a"},
+			nil,
+			[]string{"b.gpg"},
+		},
+		{
+			"syncs past pattern mismatches",
+			&mockLoader{secrets: []secret{{Name: "/a"}, {Name: "/b", Value: "b", Pattern: "^a$"}}},
+			nil,
+			nil,
+			[]string{"/a"},
+			nil,
+			[]string{"/b"},
+		},
+		{
+			"deletes past failures",
+			&mockLoader{},
+			[]pruner{&mockPruner{names: []string{"/a", "/b"}}},
+			map[string]error{"/a": fmt.Errorf("can't delete")},
+			nil,
+			[]string{"/b"},
+			[]string{"/a"},
+		},
+		{
+			"doesn't prune past load failures",
+			&mockLoader{secrets: []secret{{Name: "/a"}}, e: failures{"b.gpg": fmt.Errorf("can't decrypt")}},
+			[]pruner{&mockPruner{names: []string{"/b"}}},
+			nil,
+			[]string{"/a"},
+			nil,
+			[]string{"b.gpg"},
+		},
+		{
+			"doesn't prune past pattern mismatches",
+			&mockLoader{secrets: []secret{{Name: "/a"}, {Name: "/b", Value: "b", Pattern: "^a$"}}},
+			[]pruner{&mockPruner{names: []string{"/b"}}},
+			nil,
+			[]string{"/a"},
+			nil,
+			[]string{"/b"},
+		},
+		{
+			"nothing failed",
+			&mockLoader{secrets: []secret{{Name: "/a"}}},
+			nil,
+			nil,
+			[]string{"/a"},
+			nil,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &mockSyncer{errors: tt.errors}
+			err := run(tt.loader, s, []string{}, options{pruners: tt.pruners, keepGoing: true})
+
+			var gotFailed []string
+			if f, ok := err.(failures); ok {
+				gotFailed = f.names()
+			} else if err != nil {
+				t.Errorf("run() error = %v, want failures", err)
+			}
+			if !reflect.DeepEqual(gotFailed, tt.wantFailed) {
+				t.Errorf("run() failed = %v, want %v", gotFailed, tt.wantFailed)
+			}
+			if !reflect.DeepEqual(s.synced, tt.wantSynced) {
+				t.Errorf("synced = %v, want %v", s.synced, tt.wantSynced)
+			}
+			if !reflect.DeepEqual(s.deleted, tt.wantDeleted) {
+				t.Errorf("deleted = %v, want %v", s.deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+type mockReporter struct {
+	outcomes []outcome
+}
+
+func (m *mockReporter) Report(outcomes []outcome) error {
+	m.outcomes = outcomes
+	return nil
+}
+
+func Test_run_reports(t *testing.T) {
+	tests := []struct {
+		name      string
+		keepGoing bool
+		want      []string
+		wantErr   bool
+	}{
+		{
+			"everything, when keeping going",
+			true,
+			[]string{"/a update", "/b failed [redacted] is bad", "/c update", "/gone delete"},
+			true,
+		},
+		{
+			"up to the failure",
+			false,
+			[]string{"/a update", "/b failed [redacted] is bad"},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loader := &mockLoader{secrets: []secret{{Name: "/a"}, {Name: "/b", Value: "hunter2"}, {Name: "/c"}}}
+			s := &mockSyncer{errors: map[string]error{"/b": fmt.Errorf("hunter2 is bad")}}
+			r := &mockReporter{}
+			opts := options{pruners: []pruner{&mockPruner{names: []string{"/gone"}}}, keepGoing: tt.keepGoing, reporter: r}
+
+			if err := run(loader, s, []string{}, opts); (err != nil) != tt.wantErr {
+				t.Errorf("run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var got []string
+			for _, o := range r.outcomes {
+				got = append(got, strings.TrimSpace(fmt.Sprintf("%v %v %v", o.name, o.Action, errorString(o.err))))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("run() reported %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_summarize(t *testing.T) {
+	buf := &bytes.Buffer{}
+	summarize(buf, []outcome{
+		{name: "/prod/a", result: result{Action: updated}},
+		{name: "/prod/bb", result: result{Action: failed}, err: fmt.Errorf("bad pattern")},
+	})
+
+	want := `NAME      ACTION  ERROR
+/prod/a   update  
+/prod/bb  failed  bad pattern
+`
+	if got := buf.String(); got != want {
+		t.Errorf("summarize() = %q, want %q", got, want)
+	}
+}
+
 func Test_getPaths(t *testing.T) {
 	type args struct {
 		in   io.Reader
@@ -180,3 +521,57 @@ func Test_getPaths(t *testing.T) {
 		})
 	}
 }
+
+func Test_isDiff(t *testing.T) {
+	if isDiff([]string{"a.gpg", "secrets/b.gpg"}) {
+		t.Errorf("isDiff() of plain paths = true")
+	}
+	if !isDiff([]string{"a.gpg", "M\tb.gpg"}) {
+		t.Errorf("isDiff() of name status = false")
+	}
+}
+
+func Test_parseChanges(t *testing.T) {
+	tests := []struct {
+		name        string
+		lines       []string
+		wantPaths   []string
+		wantDeleted []string
+	}{
+		{
+			"plain paths",
+			[]string{"a.gpg", "b.description"},
+			[]string{"a.gpg", "b.description"},
+			nil,
+		},
+		{
+			"name status",
+			[]string{"A\ta.gpg", "M\tb.gpg", "D\tc.gpg", "T\td.gpg"},
+			[]string{"a.gpg", "b.gpg", "d.gpg"},
+			[]string{"c.gpg"},
+		},
+		{
+			"rename is delete plus add",
+			[]string{"R100\told.gpg\tnew.gpg"},
+			[]string{"new.gpg"},
+			[]string{"old.gpg"},
+		},
+		{
+			"copy is add",
+			[]string{"C75\tsrc.gpg\tcopy.gpg"},
+			[]string{"copy.gpg"},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths, deleted := parseChanges(tt.lines)
+			if !reflect.DeepEqual(paths, tt.wantPaths) {
+				t.Errorf("parseChanges() paths = %v, want %v", paths, tt.wantPaths)
+			}
+			if !reflect.DeepEqual(deleted, tt.wantDeleted) {
+				t.Errorf("parseChanges() deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
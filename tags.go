@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// the tag marking parameters as syncret's, which every parameter it commits gets
+const managedByTag, managedByValue = "managed-by", "syncret"
+
+// parses key=value lines into tags, ignoring blank lines and # comments
+func parseTags(lines []string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" {
+			return nil, fmt.Errorf("bad tag %q: should be key=value", line)
+		}
+		tags[key] = strings.TrimSpace(parts[1])
+	}
+	return tags, nil
+}
+
+// the tags a secret's parameter should have: its own, plus the managed-by tag
+func desiredTags(secret secret) map[string]string {
+	desired := map[string]string{managedByTag: managedByValue}
+	for key, value := range secret.Tags {
+		desired[key] = value
+	}
+	return desired
+}
+
+// the keys of the tags to set, being new or changed, and of those to remove, to go from the current
+// tags to the desired ones; in order
+func retag(current, desired map[string]string) ([]string, []string) {
+	var set, remove []string
+	for _, key := range sortedKeys(desired) {
+		if value, ok := current[key]; !ok || value != desired[key] {
+			set = append(set, key)
+		}
+	}
+	for _, key := range sortedKeys(current) {
+		if _, ok := desired[key]; !ok {
+			remove = append(remove, key)
+		}
+	}
+	return set, remove
+}
+
+// describes how retagging changes a parameter's tags, a line per tag, as changes does
+func tagChanges(current, desired map[string]string) []string {
+	quoted := func(tags map[string]string, key string) string {
+		if value, ok := tags[key]; ok {
+			return fmt.Sprintf("%q", value)
+		}
+		return "(none)"
+	}
+
+	var changes []string
+	set, remove := retag(current, desired)
+	for _, key := range append(set, remove...) {
+		changes = append(changes, fmt.Sprintf("tag %v: %v -> %v", key, quoted(current, key), quoted(desired, key)))
+	}
+	return changes
+}
+
+// makes a parameter's tags match a secret's, plus the managed-by tag, removing any others; returns
+// whether anything changed
+func (s *committer) reconcileTags(secret secret) (bool, error) {
+	desired := desiredTags(secret)
+	current, err := listTags(s, secret.Name)
+	if err != nil {
+		return false, err
+	}
+
+	set, keys := retag(current, desired)
+	var add []*ssm.Tag
+	for _, key := range set {
+		add = append(add, &ssm.Tag{Key: aws.String(key), Value: aws.String(desired[key])})
+	}
+	remove := aws.StringSlice(keys)
+
+	if len(add) > 0 {
+		if _, err := s.AddTagsToResource(&ssm.AddTagsToResourceInput{
+			ResourceType: aws.String(ssm.ResourceTypeForTaggingParameter),
+			ResourceId:   aws.String(secret.Name),
+			Tags:         add,
+		}); err != nil {
+			return false, fmt.Errorf("failed tagging %v: %v", secret.Name, err)
+		}
+	}
+
+	if len(remove) > 0 {
+		if _, err := s.RemoveTagsFromResource(&ssm.RemoveTagsFromResourceInput{
+			ResourceType: aws.String(ssm.ResourceTypeForTaggingParameter),
+			ResourceId:   aws.String(secret.Name),
+			TagKeys:      remove,
+		}); err != nil {
+			return false, fmt.Errorf("failed untagging %v: %v", secret.Name, err)
+		}
+	}
+
+	return len(add) > 0 || len(remove) > 0, nil
+}
+
+// the tags of a parameter
+func listTags(api ssmiface.SSMAPI, name string) (map[string]string, error) {
+	out, err := api.ListTagsForResource(&ssm.ListTagsForResourceInput{
+		ResourceType: aws.String(ssm.ResourceTypeForTaggingParameter),
+		ResourceId:   aws.String(name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing tags of %v: %v", name, err)
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range out.TagList {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return tags, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func Test_parseTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			"lines",
+			[]string{"owner=platform", " service = my-service ", "", "# a comment", "empty="},
+			map[string]string{"owner": "platform", "service": "my-service", "empty": ""},
+			false,
+		},
+		{
+			"values may have =",
+			[]string{"query=a=b"},
+			map[string]string{"query": "a=b"},
+			false,
+		},
+		{
+			"nothing",
+			nil,
+			map[string]string{},
+			false,
+		},
+		{
+			"no =",
+			[]string{"owner"},
+			nil,
+			true,
+		},
+		{
+			"no key",
+			[]string{"=platform"},
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTags(tt.lines)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseTags() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_committer_reconcilesTags(t *testing.T) {
+	current := map[string]*ssm.PutParameterInput{
+		"/same": makeInput(secret{Name: "/same", Value: "value"}),
+	}
+
+	tests := []struct {
+		name      string
+		client    *MockClient
+		secret    secret
+		want      action
+		wantCalls []string
+	}{
+		{
+			"tags new",
+			&MockClient{},
+			secret{Name: "/new", Tags: map[string]string{"owner": "platform"}},
+			created,
+			[]string{"add /new managed-by=syncret", "add /new owner=platform"},
+		},
+		{
+			"retags unchanged",
+			&MockClient{params: current, tags: map[string]map[string]string{
+				"/same": {managedByTag: managedByValue, "owner": "old", "stale": "yes"},
+			}},
+			secret{Name: "/same", Value: "value", Tags: map[string]string{"owner": "platform"}},
+			updated,
+			[]string{"add /same owner=platform", "remove /same stale"},
+		},
+		{
+			"leaves matching tags",
+			&MockClient{params: current, tags: map[string]map[string]string{
+				"/same": {managedByTag: managedByValue, "owner": "platform"},
+			}},
+			secret{Name: "/same", Value: "value", Tags: map[string]string{"owner": "platform"}},
+			unchanged,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&committer{tt.client}).Sync(tt.secret)
+			if err != nil {
+				t.Fatalf("committer.Sync() error = %v", err)
+			}
+			if got.Action != tt.want {
+				t.Errorf("committer.Sync() = %v, want %v", got.Action, tt.want)
+			}
+			if !reflect.DeepEqual(tt.client.tagCalls, tt.wantCalls) {
+				t.Errorf("committer.Sync() tagged %v, want %v", tt.client.tagCalls, tt.wantCalls)
+			}
+		})
+	}
+}
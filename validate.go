@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// limits of the parameter store
+const (
+	maxNameLength        = 1011
+	maxNameDepth         = 15
+	maxDescriptionLength = 1024
+	maxPatternLength     = 1024
+	maxAdvancedValueSize = 8192
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_.\-/]+$`)
+
+// a loader which can check secrets without syncing them anywhere
+type validator interface {
+	Validate(paths []string) error
+}
+
+// checks that everything in the given paths loads, and would be accepted by the parameter store
+func (l fsLoader) Validate(paths []string) error {
+	// find every problem, not just the first
+	l.keepGoing = true
+
+	problems := make(failures)
+	secrets, err := l.LoadAll(paths)
+	if errs, ok := err.(failures); ok {
+		for name, err := range errs {
+			problems[name] = err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	for _, s := range secrets {
+		if err := validateSecret(s); err != nil {
+			problems[s.Name] = err
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+// checks a secret against the parameter store's rules
+func validateSecret(s secret) error {
+	if err := validateName(s.Name); err != nil {
+		return err
+	}
+	if len(s.Description) > maxDescriptionLength {
+		return fmt.Errorf("description is %d characters, more than %d", len(s.Description), maxDescriptionLength)
+	}
+	if len(s.Pattern) > maxPatternLength {
+		return fmt.Errorf("pattern is %d characters, more than %d", len(s.Pattern), maxPatternLength)
+	}
+
+	// values too big for a standard parameter are bumped to advanced, as are any with policies
+	if len(s.Value) > maxAdvancedValueSize {
+		return fmt.Errorf("value is %d bytes, more than the %d an advanced parameter holds", len(s.Value), maxAdvancedValueSize)
+	}
+	return checkPattern(s)
+}
+
+func validateName(name string) error {
+	if len(name) > maxNameLength {
+		return fmt.Errorf("name is %d characters, more than %d", len(name), maxNameLength)
+	}
+	if !validName.MatchString(name) {
+		return fmt.Errorf("bad name %v: only letters, numbers and _.-/ are allowed", name)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	if len(parts) > maxNameDepth {
+		return fmt.Errorf("bad name %v: %d levels deep, more than %d", name, len(parts), maxNameDepth)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return fmt.Errorf("bad name %v: empty level", name)
+		}
+	}
+
+	first := strings.ToLower(parts[0])
+	if strings.HasPrefix(first, "aws") || strings.HasPrefix(first, "ssm") {
+		return fmt.Errorf("bad name %v: can't begin with aws or ssm", name)
+	}
+	return nil
+}
+
+// `syncret validate [paths]`: check secrets without syncing them, or needing AWS at all
+func validateCmd(args []string) error {
+	loader, err := newLoader()
+	if err != nil {
+		return err
+	}
+
+	v, ok := loader.(validator)
+	if !ok {
+		return fmt.Errorf("can't validate these secrets")
+	}
+
+	paths := getPaths(os.Stdin, args)
+	err = v.Validate(paths)
+	if problems, ok := err.(failures); ok {
+		for _, name := range problems.names() {
+			log.Printf("Invalid: %v: %v", name, problems[name])
+		}
+		return fmt.Errorf("found %d problems in %d paths", len(problems), len(paths))
+	} else if err != nil {
+		return err
+	}
+	log.Printf("All %d paths are valid", len(paths))
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_validateSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  secret
+		wantErr bool
+	}{
+		{"valid", secret{Name: "/prod/my-service/DB_URL", Value: "value", Description: "desc", Pattern: "^v"}, false},
+		{"no leading slash", secret{Name: "DB_URL", Value: "value"}, false},
+		{"bad characters", secret{Name: "/prod/my service/DB_URL", Value: "value"}, true},
+		{"too deep", secret{Name: "/" + strings.Repeat("a/", 15) + "b", Value: "value"}, true},
+		{"deep enough", secret{Name: "/" + strings.Repeat("a/", 14) + "b", Value: "value"}, false},
+		{"empty level", secret{Name: "/prod//DB_URL", Value: "value"}, true},
+		{"name too long", secret{Name: "/" + strings.Repeat("a", 1011), Value: "value"}, true},
+		{"reserved prefix", secret{Name: "/aws/DB_URL", Value: "value"}, true},
+		{"reserved prefix any case", secret{Name: "/SSM-things/DB_URL", Value: "value"}, true},
+		{"description too long", secret{Name: "/a", Value: "value", Description: strings.Repeat("a", 1025)}, true},
+		{"pattern too long", secret{Name: "/a", Value: "value", Pattern: strings.Repeat("v", 1025)}, true},
+		{"advanced value", secret{Name: "/a", Value: strings.Repeat("a", 8192)}, false},
+		{"value too big", secret{Name: "/a", Value: strings.Repeat("a", 8193)}, true},
+		{"pattern mismatch", secret{Name: "/a", Value: "value", Pattern: "^x"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSecret(tt.secret); (err != nil) != tt.wantErr {
+				t.Errorf("validateSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_fsLoader_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		paths []string
+		want  []string
+	}{
+		{
+			"valid",
+			map[string]string{"prod/a.gpg": "a", "prod/a.pattern": "^a$", "prod/a.description": "desc"},
+			[]string{"prod/a.gpg", "prod/a.pattern", "prod/a.description"},
+			nil,
+		},
+		{
+			"orphaned sidecars",
+			map[string]string{"prod/a.gpg": "a", "prod/b.pattern": "^b$", "prod/c.description": "desc"},
+			[]string{"prod/a.gpg", "prod/b.pattern", "prod/c.description"},
+			[]string{"prod/b.pattern", "prod/c.description"},
+		},
+		{
+			"bad secrets",
+			map[string]string{"prod/a.gpg": "a", "prod/a.pattern": "^b$", "prod/b c.gpg": "b", "prod/d.gpg": "d"},
+			[]string{"prod/a.gpg", "prod/b c.gpg", "prod/d.gpg", "prod/e.gpg"},
+			[]string{"/prod/a", "/prod/b c", "prod/e.gpg"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpdir := testDir(t)
+			defer os.RemoveAll(tmpdir)
+
+			setUpFs(tmpdir, tt.files)
+
+			l := fsLoader{
+				decryptors:        map[string]decryptor{".gpg": fakeDecryptor{}},
+				descriptionSuffix: ".description",
+				patternSuffix:     ".pattern",
+				kmsSuffix:         ".kms",
+				typeSuffix:        ".type",
+				tagsSuffix:        ".tags",
+				policiesSuffix:    ".policies",
+				rootDir:           tmpdir,
+			}
+			err := l.Validate(tt.paths)
+			var got []string
+			if problems, ok := err.(failures); ok {
+				got = problems.names()
+			} else if err != nil {
+				t.Fatalf("fsLoader.Validate() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fsLoader.Validate() failed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
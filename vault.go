@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	vaultAddrEnvVar      = "VAULT_ADDR"
+	vaultTokenEnvVar     = "VAULT_TOKEN"
+	vaultNamespaceEnvVar = "VAULT_NAMESPACE"
+	vaultRoleIDEnvVar    = "VAULT_ROLE_ID"
+	vaultSecretIDEnvVar  = "VAULT_SECRET_ID"
+)
+
+var vaultMount = flag.String("vault-mount", "secret", "The KV v2 secrets engine mount -target vault syncs to")
+
+// return a new syncer which commits values to vault, configured and authenticated from the environment
+func newVaultCommitter() (syncer, error) {
+	return doNewVaultCommitter(envMap(os.Environ()), *vaultMount, http.DefaultClient)
+}
+
+// responsible for authenticating, with a token or else an AppRole login
+func doNewVaultCommitter(env map[string]string, mount string, client *http.Client) (*vaultCommitter, error) {
+	addr := strings.TrimRight(env[vaultAddrEnvVar], "/")
+	if addr == "" {
+		return nil, fmt.Errorf("no vault address: set %v", vaultAddrEnvVar)
+	}
+
+	v := &vaultCommitter{
+		client:    client,
+		addr:      addr,
+		mount:     strings.Trim(mount, "/"),
+		token:     env[vaultTokenEnvVar],
+		namespace: env[vaultNamespaceEnvVar],
+	}
+
+	if v.token == "" {
+		if env[vaultRoleIDEnvVar] == "" {
+			return nil, fmt.Errorf("no vault credentials: set %v, or %v and %v",
+				vaultTokenEnvVar, vaultRoleIDEnvVar, vaultSecretIDEnvVar)
+		}
+
+		var login struct {
+			Auth struct {
+				ClientToken string `json:"client_token"`
+			} `json:"auth"`
+		}
+		if _, err := v.do("POST", "auth/approle/login", map[string]string{
+			"role_id":   env[vaultRoleIDEnvVar],
+			"secret_id": env[vaultSecretIDEnvVar],
+		}, &login); err != nil {
+			return nil, fmt.Errorf("failed logging in to vault: %v", err)
+		}
+		v.token = login.Auth.ClientToken
+	}
+
+	return v, nil
+}
+
+// commits secrets to a vault KV v2 secrets engine: the value as the "value" key of the secret, and the
+// description and pattern as its custom metadata
+type vaultCommitter struct {
+	client    *http.Client
+	addr      string
+	mount     string
+	token     string
+	namespace string
+}
+
+func (s *vaultCommitter) Sync(secret secret) (result, error) {
+	path := vaultPath(secret.Name)
+
+	var current struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	status, err := s.do("GET", s.mount+"/data/"+path, nil, &current)
+	if err != nil && status != http.StatusNotFound {
+		return result{}, fmt.Errorf("failed fetching %v: %v", secret.Name, err)
+	}
+	exists := status != http.StatusNotFound
+
+	var meta struct {
+		Data struct {
+			CustomMetadata map[string]string `json:"custom_metadata"`
+		} `json:"data"`
+	}
+	if status, err = s.do("GET", s.mount+"/metadata/"+path, nil, &meta); err != nil && status != http.StatusNotFound {
+		return result{}, fmt.Errorf("failed fetching metadata for %v: %v", secret.Name, err)
+	}
+
+	desired := map[string]string{"description": secret.Description, "pattern": secret.Pattern}
+	valueChanged := !exists || current.Data.Data["value"] != secret.Value
+	metadataChanged := meta.Data.CustomMetadata["description"] != secret.Description ||
+		meta.Data.CustomMetadata["pattern"] != secret.Pattern
+
+	if !valueChanged && !metadataChanged {
+		return result{Action: unchanged}, nil
+	}
+
+	if valueChanged {
+		if _, err := s.do("POST", s.mount+"/data/"+path, map[string]interface{}{
+			"data": map[string]string{"value": secret.Value},
+		}, nil); err != nil {
+			return result{}, fmt.Errorf("failed uploading %v: %v", secret.Name, err)
+		}
+	}
+
+	if metadataChanged {
+		if _, err := s.do("POST", s.mount+"/metadata/"+path, map[string]interface{}{
+			"custom_metadata": desired,
+		}, nil); err != nil {
+			return result{}, fmt.Errorf("failed updating metadata for %v: %v", secret.Name, err)
+		}
+	}
+
+	if !exists {
+		return result{Action: created}, nil
+	}
+	return result{Action: updated}, nil
+}
+
+// soft deletes the latest version, which vault can still undelete
+func (s *vaultCommitter) Delete(name string) error {
+	if status, err := s.do("DELETE", s.mount+"/data/"+vaultPath(name), nil, nil); err != nil && status != http.StatusNotFound {
+		return fmt.Errorf("failed deleting %v: %v", name, err)
+	}
+	return nil
+}
+
+// makes a request to the vault api, decoding any response into out; errors include the status, if any
+func (s *vaultCommitter) do(method, path string, in, out interface{}) (int, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, s.addr+"/v1/"+path, body)
+	if err != nil {
+		return 0, err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Vault-Token", s.token)
+	}
+	if s.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return resp.StatusCode, fmt.Errorf("%v: %v", resp.Status, strings.Join(failure.Errors, "; "))
+	}
+
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, err
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// vault paths are relative, with each segment escaped: /prod/my-service/KEY -> prod/my-service/KEY
+func vaultPath(name string) string {
+	segments := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
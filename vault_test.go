@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type mockVaultSecret struct {
+	value    string
+	metadata map[string]string
+}
+
+// a fake of the slice of the vault http api syncret uses, with a kv v2 engine mounted at "secret"
+type fakeVault struct {
+	token   string
+	broken  bool
+	secrets map[string]*mockVaultSecret
+	calls   []string
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if v.broken {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string][]string{"errors": {"broken"}})
+		return
+	}
+
+	if r.URL.Path == "/v1/auth/approle/login" {
+		var login map[string]string
+		json.NewDecoder(r.Body).Decode(&login)
+		if login["role_id"] != "role" || login["secret_id"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]string{"client_token": v.token}})
+		return
+	}
+
+	if r.Header.Get("X-Vault-Token") != v.token {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var kind, path string
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+		kind, path = "data", strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/"):
+		kind, path = "metadata", strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/")
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s, ok := v.secrets[path]
+	switch r.Method {
+	case "GET":
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if kind == "data" {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"data": map[string]string{"value": s.value}},
+			})
+		} else {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"custom_metadata": s.metadata},
+			})
+		}
+	case "POST":
+		v.calls = append(v.calls, "write "+kind+" "+path)
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		v.calls = append(v.calls, "delete "+path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func newTestVaultCommitter(t *testing.T, v *fakeVault) *vaultCommitter {
+	server := httptest.NewServer(v)
+	t.Cleanup(server.Close)
+	return &vaultCommitter{client: server.Client(), addr: server.URL, mount: "secret", token: v.token}
+}
+
+func Test_doNewVaultCommitter(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		wantToken string
+		wantErr   bool
+	}{
+		{
+			"token",
+			map[string]string{vaultTokenEnvVar: "token"},
+			"token",
+			false,
+		},
+		{
+			"approle",
+			map[string]string{vaultRoleIDEnvVar: "role", vaultSecretIDEnvVar: "secret"},
+			"issued",
+			false,
+		},
+		{
+			"approle rejected",
+			map[string]string{vaultRoleIDEnvVar: "role", vaultSecretIDEnvVar: "wrong"},
+			"",
+			true,
+		},
+		{
+			"no credentials",
+			map[string]string{},
+			"",
+			true,
+		},
+		{
+			"no address",
+			map[string]string{vaultTokenEnvVar: "token", vaultAddrEnvVar: ""},
+			"",
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(&fakeVault{token: "issued"})
+			defer server.Close()
+
+			env := map[string]string{vaultAddrEnvVar: server.URL}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+
+			got, err := doNewVaultCommitter(env, "/secret/", server.Client())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("doNewVaultCommitter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.token != tt.wantToken || got.mount != "secret" {
+				t.Errorf("doNewVaultCommitter() = token %v, mount %v, want %v, secret", got.token, got.mount, tt.wantToken)
+			}
+		})
+	}
+}
+
+func Test_vaultCommitter_Sync(t *testing.T) {
+	existing := func() map[string]*mockVaultSecret {
+		return map[string]*mockVaultSecret{
+			"prod/same": {value: "value", metadata: map[string]string{"description": "desc", "pattern": ""}},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		vault     *fakeVault
+		secret    secret
+		want      action
+		wantCalls []string
+		wantErr   bool
+	}{
+		{
+			"creates new",
+			&fakeVault{token: "token", secrets: existing()},
+			secret{Name: "/prod/new", Value: "value", Description: "desc"},
+			created,
+			[]string{"write data prod/new", "write metadata prod/new"},
+			false,
+		},
+		{
+			"skips unchanged",
+			&fakeVault{token: "token", secrets: existing()},
+			secret{Name: "/prod/same", Value: "value", Description: "desc"},
+			unchanged,
+			nil,
+			false,
+		},
+		{
+			"writes changed value",
+			&fakeVault{token: "token", secrets: existing()},
+			secret{Name: "/prod/same", Value: "new value", Description: "desc"},
+			updated,
+			[]string{"write data prod/same"},
+			false,
+		},
+		{
+			"writes changed metadata",
+			&fakeVault{token: "token", secrets: existing()},
+			secret{Name: "/prod/same", Value: "value", Description: "desc", Pattern: "^v"},
+			updated,
+			[]string{"write metadata prod/same"},
+			false,
+		},
+		{
+			"propagates error",
+			&fakeVault{token: "token", broken: true},
+			secret{Name: "/prod/new", Value: "value"},
+			"",
+			nil,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newTestVaultCommitter(t, tt.vault).Sync(tt.secret)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("vaultCommitter.Sync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Action != tt.want {
+				t.Errorf("vaultCommitter.Sync() = %v, want %v", got.Action, tt.want)
+			}
+			if !reflect.DeepEqual(tt.vault.calls, tt.wantCalls) {
+				t.Errorf("vaultCommitter.Sync() called %v, want %v", tt.vault.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func Test_vaultCommitter_Delete(t *testing.T) {
+	tests := []struct {
+		name      string
+		vault     *fakeVault
+		wantCalls []string
+		wantErr   bool
+	}{
+		{
+			"deletes",
+			&fakeVault{token: "token", secrets: map[string]*mockVaultSecret{"prod/gone": {}}},
+			[]string{"delete prod/gone"},
+			false,
+		},
+		{
+			"already gone is fine",
+			&fakeVault{token: "token"},
+			nil,
+			false,
+		},
+		{
+			"propagates error",
+			&fakeVault{token: "token", broken: true},
+			nil,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := newTestVaultCommitter(t, tt.vault).Delete("/prod/gone"); (err != nil) != tt.wantErr {
+				t.Errorf("vaultCommitter.Delete() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(tt.vault.calls, tt.wantCalls) {
+				t.Errorf("vaultCommitter.Delete() called %v, want %v", tt.vault.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func Test_vaultPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"/prod/my-service/KEY", "prod/my-service/KEY"},
+		{"prod/a b", "prod/a%20b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := vaultPath(tt.name); got != tt.want {
+				t.Errorf("vaultPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
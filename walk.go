@@ -0,0 +1,191 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	ignore "github.com/sabhiram/go-gitignore"
+)
+
+// excludes paths from directory walks, in gitignore syntax, relative to its directory
+const ignoreFileName = ".syncretignore"
+
+// expands any directories among the paths into the secret files under them, and drops any paths which a
+// .syncretignore in their directory or above it (up to the root directory) excludes; anything else, even
+// if missing, is left as it is for loading
+func (l fsLoader) expand(paths []string) ([]string, error) {
+	var expanded []string
+	for _, p := range paths {
+		info, err := os.Stat(resolve(l.rootDir, p))
+		if err != nil || !info.IsDir() {
+			ignored, err := l.ignored(p)
+			if err != nil {
+				return nil, err
+			}
+			if ignored {
+				log.Printf("Ignoring %v", p)
+			} else {
+				expanded = append(expanded, p)
+			}
+			continue
+		}
+
+		files, err := l.walk(p)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, files...)
+	}
+	return expanded, nil
+}
+
+// whether a path is an ignore file, which only changes which paths are synced, and isn't synced itself
+func isIgnoreFile(p string) bool {
+	return path.Base(p) == ignoreFileName
+}
+
+// the ignore files which apply to a path, each relative to its own directory
+type ignorers []ignorer
+
+type ignorer struct {
+	dir string // absolute, since ignore files above a walked directory apply too
+	*ignore.GitIgnore
+}
+
+// adds the ignore file in a directory, if there is one
+func (is *ignorers) load(dir string) error {
+	i, err := ignore.CompileIgnoreFile(filepath.Join(dir, ignoreFileName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	*is = append(*is, ignorer{dir, i})
+	return nil
+}
+
+// whether an absolute path is excluded by any ignore file above it
+func (is ignorers) ignores(full string, isDir bool) bool {
+	for _, i := range is {
+		rel, err := filepath.Rel(i.dir, full)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if isDir {
+			rel += "/"
+		}
+		if i.MatchesPath(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// whether a file, which needn't exist, is excluded by an ignore file, or is in an excluded directory
+func (l fsLoader) ignored(p string) (bool, error) {
+	full, err := filepath.Abs(resolve(l.rootDir, p))
+	if err != nil {
+		return false, err
+	}
+	ancestors, err := l.ancestors(filepath.Dir(full))
+	if err != nil {
+		return false, err
+	}
+	dirs := append(ancestors, filepath.Dir(full))
+
+	var is ignorers
+	for _, dir := range dirs {
+		if err := is.load(dir); err != nil {
+			return false, err
+		}
+	}
+	for _, dir := range dirs[1:] {
+		if is.ignores(dir, true) {
+			return true, nil
+		}
+	}
+	return is.ignores(full, false), nil
+}
+
+// the files under a directory which the loader recognizes, in lexical order
+func (l fsLoader) walk(dir string) ([]string, error) {
+	root, err := filepath.Abs(resolve(l.rootDir, dir))
+	if err != nil {
+		return nil, err
+	}
+	ancestors, err := l.ancestors(root)
+	if err != nil {
+		return nil, err
+	}
+	var is ignorers
+	for _, a := range ancestors {
+		if err := is.load(a); err != nil {
+			return nil, err
+		}
+	}
+
+	var files []string
+	err = filepath.Walk(root, func(full string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if is.ignores(full, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			// walked after the directory, so everything under it is checked against its ignore file
+			return is.load(full)
+		}
+
+		rel, err := filepath.Rel(root, full)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if l.recognizes(rel) {
+			files = append(files, path.Join(dir, rel))
+		}
+		return nil
+	})
+	return files, err
+}
+
+// the directories above an absolute one, from the root directory (or the working directory) down, whose
+// ignore files apply to it; none if it's not under the root
+func (l fsLoader) ancestors(dir string) ([]string, error) {
+	base := l.rootDir
+	if base == "" {
+		base = "."
+	}
+	base, err := filepath.Abs(base)
+	if err != nil {
+		return nil, err
+	}
+
+	rel, err := filepath.Rel(base, dir)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return nil, nil
+	}
+	ancestors := []string{base}
+	parts := strings.Split(rel, string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		ancestors = append(ancestors, filepath.Join(ancestors[len(ancestors)-1], part))
+	}
+	return ancestors, nil
+}
+
+// whether a file found walking is one to load; directory KMS defaults aren't, though they're sidecars
+func (l fsLoader) recognizes(p string) bool {
+	if path.Base(p) == l.kmsSuffix {
+		return false
+	}
+	return unextended(p, documentSuffixes...) != "" || unextended(p, l.suffixes()...) != ""
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_fsLoader_expand(t *testing.T) {
+	files := map[string]string{
+		"secrets/README.md":                 "not a secret",
+		"secrets/.syncretignore":            "drafts/\n*.bak.gpg\n",
+		"secrets/prod/.kms":                 "alias/prod",
+		"secrets/prod/b/KEY.gpg":            "b",
+		"secrets/prod/a/DB_URL.gpg":         "a",
+		"secrets/prod/a/DB_URL.pattern":     "^a$",
+		"secrets/prod/a/DB_URL.description": "the db",
+		"secrets/prod/a/OLD.bak.gpg":        "old",
+		"secrets/prod/app.sops.yaml":        "KEY: value",
+		"secrets/drafts/NEW.gpg":            "new",
+		"secrets/staging/.syncretignore":    "LEGACY_*\n!LEGACY_KEEP.gpg\n",
+		"secrets/staging/LEGACY_A.gpg":      "legacy",
+		"secrets/staging/LEGACY_KEEP.gpg":   "keep",
+		"secrets/staging/URL.txt":           "https://example.com",
+		"other/drafts/X.gpg":                "not ignored here",
+	}
+
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{
+			"walks in order",
+			[]string{"secrets"},
+			[]string{
+				"secrets/prod/a/DB_URL.description",
+				"secrets/prod/a/DB_URL.gpg",
+				"secrets/prod/a/DB_URL.pattern",
+				"secrets/prod/app.sops.yaml",
+				"secrets/prod/b/KEY.gpg",
+				"secrets/staging/LEGACY_KEEP.gpg",
+				"secrets/staging/URL.txt",
+			},
+		},
+		{
+			"ignore files only apply under their directory",
+			[]string{"other", "secrets/staging/"},
+			[]string{"other/drafts/X.gpg", "secrets/staging/LEGACY_KEEP.gpg", "secrets/staging/URL.txt"},
+		},
+		{
+			"ignore files above the walked directory apply",
+			[]string{"secrets/prod", "secrets/drafts"},
+			[]string{
+				"secrets/prod/a/DB_URL.description",
+				"secrets/prod/a/DB_URL.gpg",
+				"secrets/prod/a/DB_URL.pattern",
+				"secrets/prod/app.sops.yaml",
+				"secrets/prod/b/KEY.gpg",
+			},
+		},
+		{
+			"leaves files alone",
+			[]string{"secrets/gone.gpg", "secrets/prod/b", "other/drafts/X.gpg"},
+			[]string{"secrets/gone.gpg", "secrets/prod/b/KEY.gpg", "other/drafts/X.gpg"},
+		},
+		{
+			"ignore files apply to files too",
+			[]string{
+				"secrets/drafts/NEW.gpg",
+				"secrets/drafts/GONE.gpg",
+				"secrets/prod/a/OLD.bak.gpg",
+				"secrets/staging/LEGACY_A.gpg",
+				"secrets/staging/LEGACY_KEEP.gpg",
+			},
+			[]string{"secrets/staging/LEGACY_KEEP.gpg"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpdir := testDir(t)
+			defer os.RemoveAll(tmpdir)
+
+			setUpFs(tmpdir, files)
+
+			l := fsLoader{
+				decryptors:        map[string]decryptor{".gpg": fakeDecryptor{}, ".txt": plaintextDecryptor{}},
+				descriptionSuffix: ".description",
+				patternSuffix:     ".pattern",
+				kmsSuffix:         ".kms",
+				typeSuffix:        ".type",
+				tagsSuffix:        ".tags",
+				policiesSuffix:    ".policies",
+				rootDir:           tmpdir,
+			}
+			got, err := l.expand(tt.paths)
+			if err != nil {
+				t.Fatalf("fsLoader.expand() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fsLoader.expand() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}